@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive [group...]",
+	Short: "Store a time window of one or more groups in the local archive",
+	Long: `Store a time window of one or more groups in the local archive.
+
+Archived windows are answered locally by 'loro search --local', which only
+fetches the parts of a window missing from the archive. Use --list to show
+the windows held for each group.`,
+	RunE: archiveLogs,
+}
+
+var archiveList bool
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+	archiveCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	archiveCmd.Flags().StringVarP(&since, "since", "s", "1h", "Archive logs since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	archiveCmd.Flags().StringVarP(&until, "until", "u", "now", "Archive logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	archiveCmd.Flags().IntVar(&shards, "shards", 1, "Split the time window into this many shards fetched in parallel")
+	archiveCmd.Flags().IntVar(&workers, "workers", lib.DefaultBackfillWorkers, "Maximum number of shards fetched at once")
+	archiveCmd.Flags().StringVar(&archiveDir, "archive-dir", "", "Directory of the local archive (default is the user cache directory)")
+	archiveCmd.Flags().BoolVar(&archiveList, "list", false, "List the archived windows instead of fetching")
+}
+
+func archiveLogs(cmd *cobra.Command, args []string) error {
+	archive, err := openArchive()
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if archiveList {
+		return listArchive(archive, args)
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("at least one group is required")
+	}
+
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", since)
+	}
+	end, err := lib.GetTime(until, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", until)
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	svc, err := lib.NewCloudwatchLogsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	groupNames, err := lib.ExpandLogGroups(ctx, svc, args)
+	if err != nil {
+		return err
+	}
+
+	progress := newBackfillProgress(os.Stderr)
+	defer progress.done()
+
+	for _, group := range groupNames {
+		logReader, err := lib.NewCloudwatchLogsReaderWithSource(svc, group, prefix, start, end)
+		if err != nil {
+			return err
+		}
+		logReader.SetNotifyFunc(notify)
+		logReader.SetArchive(archive, false)
+		if shards > 1 {
+			logReader.SetShards(shards, workers)
+			logReader.SetProgressFunc(progress.update)
+		}
+
+		count := 0
+		for range logReader.StreamEvents(ctx, false) {
+			count++
+		}
+		if err := logReader.Error(); err != nil {
+			return err
+		}
+		notify(fmt.Sprintf("archived %d events from '%s'", count, group))
+	}
+
+	return nil
+}
+
+// listArchive prints the archived windows of groups, or of every archived
+// group if none is given
+func listArchive(archive *lib.Archive, groups []string) error {
+	if len(groups) == 0 {
+		var err error
+		groups, err = archive.Groups()
+		if err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tPREFIX\tSTART\tEND")
+	for _, group := range groups {
+		coverage, err := archive.Coverage(group)
+		if err != nil {
+			return err
+		}
+		for _, window := range coverage {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", group, window.Prefix,
+				window.Start.Local().Format(time.RFC3339), window.End.Local().Format(time.RFC3339))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultAroundFormatString      = `[ {{ uniquecolor (print .Stream) }} ] {{ printf "%8s" .Offset }} {{ .TimeShort }} - {{ .Event.message }}`
+	defaultAroundGroupFormatString = `[ {{ uniquecolor (print .Group) }} {{ uniquecolor (print .Stream) }} ] {{ printf "%8s" .Offset }} {{ .TimeShort }} - {{ .Event.message }}`
+	// aroundTimeFormat is the format of the time on the reference marker
+	aroundTimeFormat = "2006-01-02 15:04:05.000"
+)
+
+// aroundCmd represents the around command
+var aroundCmd = &cobra.Command{
+	Use:   "around <timestamp> [group...]",
+	Short: "Show the events of one or more groups around a point in time",
+	Long: `Show the events of one or more groups around a point in time.
+
+The timestamp can be absolute (e.g. 2013-01-02T13:23:37) or relative (e.g.
+42m for 42 minutes ago). Events of every stream of the groups logged within
+--window of it are shown in order, with a marker at the timestamp and the
+offset of each event from it (e.g. +1.2s), available as .Offset in --format
+templates.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: around,
+}
+
+var aroundWindow time.Duration
+
+// aroundColumns are the default columns of csv output
+var aroundColumns = []string{"time", "offset", "group", "stream", "message"}
+
+func init() {
+	rootCmd.AddCommand(aroundCmd)
+	aroundCmd.Flags().DurationVarP(&aroundWindow, "window", "w", time.Minute, "Show events logged up to this long before and after the timestamp")
+	aroundCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	aroundCmd.Flags().StringVarP(&eventTemplate, "format", "o", defaultAroundFormatString, "Format template for displaying log events")
+	aroundCmd.Flags().BoolVarP(&raw, "raw", "r", false, "Raw JSON output")
+	aroundCmd.Flags().StringVar(&outputFormat, "output", "text", "Output mode, one of: text (uses --format), "+strings.Join(lib.Encoders(), ", "))
+	aroundCmd.Flags().StringSliceVar(&columns, "columns", aroundColumns, "Columns for csv output: time, ingestion_time, group, stream, region, account, id, offset or a dotted path into the event (e.g. request.path)")
+	aroundCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	aroundCmd.Flags().StringVar(&whereExpr, "where", "", "Only show events whose parsed fields match an expression (e.g. 'level == \"error\"')")
+	aroundCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+}
+
+func around(cmd *cobra.Command, args []string) error {
+	reference, err := lib.GetTime(args[0], time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", args[0])
+	}
+	if aroundWindow <= 0 {
+		return fmt.Errorf("--window must be positive")
+	}
+
+	var where *lib.Where
+	if whereExpr != "" {
+		where, err = lib.ParseWhere(whereExpr)
+		if err != nil {
+			return err
+		}
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	logReaders, eventChans, err := readGroups(ctx, args[1:], reference.Add(-aroundWindow), reference.Add(aroundWindow), false, parser, nil)
+	if err != nil {
+		return err
+	}
+
+	// The flags share their variables with other commands, set the defaults
+	// of this one unless given. Show the group of each event when reading
+	// several.
+	if !cmd.Flags().Lookup("format").Changed {
+		eventTemplate = defaultAroundFormatString
+		if len(logReaders) > 1 {
+			eventTemplate = defaultAroundGroupFormatString
+		}
+	}
+	if !cmd.Flags().Lookup("columns").Changed {
+		columns = aroundColumns
+	}
+	output, err := newEventEncoder(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	window := []lib.Event{}
+	for event := range lib.MergeEvents(ctx, 0, 0, eventChans...) {
+		if where == nil || where.Match(event) {
+			event.Reference = reference
+			window = append(window, event)
+		}
+	}
+	if err := readersError(ctx, logReaders); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		notify("interrupted, showing the events found so far")
+	}
+
+	sort.SliceStable(window, func(i, j int) bool {
+		return window[i].CreationTime.Before(window[j].CreationTime)
+	})
+
+	// The marker is only part of text output, other formats have the offset
+	marker := outputFormat == "text" && !raw
+	for _, event := range window {
+		if marker && !event.CreationTime.Before(reference) {
+			printAroundMarker(reference)
+			marker = false
+		}
+		if err := output.Encode(event); err != nil {
+			return err
+		}
+	}
+	if marker {
+		printAroundMarker(reference)
+	}
+
+	return nil
+}
+
+// printAroundMarker prints the line marking the reference time between the
+// events before and after it
+func printAroundMarker(reference time.Time) {
+	fmt.Println(lib.Yellow(fmt.Sprintf("──────── %s ────────", reference.Local().Format(aroundTimeFormat))))
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration file and its views",
+	Long: `Inspect the configuration file and its views.
+
+Flag defaults can be set per command in the configuration file, under the
+command name (e.g. get.since or list.streams.max-streams). Views are named
+sets of groups and get flags under views.<name>, used as 'loro get @name'.`,
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the views defined in the configuration file",
+	Args:  cobra.NoArgs,
+	RunE:  configList,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [view]",
+	Short: "Show the configuration, or the settings of a view",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  configShow,
+}
+
+// userFlags holds the flags given on the command line, which take precedence
+// over the configuration file and views
+var userFlags = map[string]bool{}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+// applyConfigDefaults sets the flags of cmd not given on the command line
+// from the configuration file section of the command
+func applyConfigDefaults(cmd *cobra.Command) error {
+	section := strings.ReplaceAll(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "), " ", ".")
+
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			userFlags[flag.Name] = true
+			return
+		}
+		if err != nil || flag.Name == "help" || flag.Name == "config" || isAWSFlag(flag.Name) {
+			return
+		}
+
+		key := section + "." + flag.Name
+		if !viper.IsSet(key) {
+			return
+		}
+		if setErr := setFlag(cmd, flag, viper.GetViper(), key); setErr != nil {
+			err = fmt.Errorf("invalid value for '%s' in config: %w", key, setErr)
+		}
+	})
+
+	return err
+}
+
+// untilOrFollow reports whether --until applies to a command that can also
+// --follow. The two can't be given together, but a value of one from the
+// configuration file or a view gives way to the other given on the command
+// line.
+func untilOrFollow(cmd *cobra.Command) (bool, error) {
+	if !cmd.Flags().Lookup("until").Changed {
+		return false, nil
+	}
+	if !follow {
+		return true, nil
+	}
+
+	switch {
+	case userFlags["follow"] && !userFlags["until"]:
+		return false, nil
+	case userFlags["until"] && !userFlags["follow"]:
+		follow = false
+		return true, nil
+	}
+	return false, fmt.Errorf("can't set both --until and --follow")
+}
+
+func isAWSFlag(name string) bool {
+	for _, f := range awsFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setFlag sets a flag from a configuration key, lists are accepted for slice
+// flags
+func setFlag(cmd *cobra.Command, flag *pflag.Flag, v *viper.Viper, key string) error {
+	value := v.GetString(key)
+	if strings.HasSuffix(flag.Value.Type(), "Slice") {
+		value = strings.Join(v.GetStringSlice(key), ",")
+	}
+	return cmd.Flags().Set(flag.Name, value)
+}
+
+// applyViews replaces @name arguments with the groups of the named views and
+// sets the flags they define, unless they were given on the command line
+func applyViews(cmd *cobra.Command, args []string) ([]string, error) {
+	groups := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			groups = append(groups, arg)
+			continue
+		}
+
+		name := strings.TrimPrefix(arg, "@")
+		view := viper.Sub("views." + name)
+		if view == nil {
+			return nil, fmt.Errorf("unknown view '%s', see loro config list", name)
+		}
+
+		for _, key := range view.AllKeys() {
+			if key == "groups" {
+				groups = append(groups, view.GetStringSlice(key)...)
+				continue
+			}
+			if userFlags[key] {
+				continue
+			}
+
+			flag := cmd.Flags().Lookup(key)
+			if flag == nil || key == "help" || isAWSFlag(key) {
+				return nil, fmt.Errorf("view '%s' has unknown setting '%s'", name, key)
+			}
+			if err := setFlag(cmd, flag, view, key); err != nil {
+				return nil, fmt.Errorf("invalid value for '%s' in view '%s': %w", key, name, err)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// views returns the views defined in the configuration file
+func views() map[string]*viper.Viper {
+	views := map[string]*viper.Viper{}
+	for name := range viper.GetStringMap("views") {
+		if view := viper.Sub("views." + name); view != nil {
+			views[name] = view
+		}
+	}
+	return views
+}
+
+func configList(cmd *cobra.Command, args []string) error {
+	all := views()
+	if len(all) == 0 {
+		notify("no views defined, add them under 'views' in the config file")
+		return nil
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIEW\tGROUPS\tSETTINGS")
+	for _, name := range names {
+		view := all[name]
+		settings := []string{}
+		for _, key := range view.AllKeys() {
+			if key != "groups" {
+				settings = append(settings, key)
+			}
+		}
+		sort.Strings(settings)
+		fmt.Fprintf(w, "@%s\t%s\t%s\n", name, strings.Join(view.GetStringSlice("groups"), ","), strings.Join(settings, ","))
+	}
+	return w.Flush()
+}
+
+func configShow(cmd *cobra.Command, args []string) error {
+	settings := viper.AllSettings()
+	if len(args) > 0 {
+		name := strings.TrimPrefix(args[0], "@")
+		view := viper.Sub("views." + name)
+		if view == nil {
+			return fmt.Errorf("unknown view '%s', see loro config list", name)
+		}
+		settings = view.AllSettings()
+	} else if file := viper.ConfigFileUsed(); file != "" {
+		fmt.Printf("# %s\n", file)
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(settings); err != nil {
+		return err
+	}
+	return enc.Close()
+}
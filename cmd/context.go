@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/spf13/cobra"
+)
+
+// contextFlushDelay is how long the context of a match is held while
+// following, waiting for a nearby match to join it with
+const contextFlushDelay = 2 * time.Second
+
+// newContextLines returns the ContextLines selected by -A, -B and -C, or nil
+// when no context is requested
+func newContextLines(cmd *cobra.Command) (*lib.ContextLines, error) {
+	before, after := contextBefore, contextAfter
+	if !cmd.Flags().Changed("before-context") {
+		before = contextAround
+	}
+	if !cmd.Flags().Changed("after-context") {
+		after = contextAround
+	}
+	if before == 0 && after == 0 {
+		return nil, nil
+	}
+
+	if after > 0 && follow {
+		return nil, fmt.Errorf("events after a match can't be shown with --follow, use --before-context")
+	}
+	return lib.NewContextLines(before, after)
+}
+
+// contextPrinter writes the groups of events around matches, separated by a
+// -- line in text output like grep does
+type contextPrinter struct {
+	out      io.Writer
+	output   lib.Encoder
+	separate bool
+	printed  bool
+	stream   string
+}
+
+func (p *contextPrinter) print(groups []lib.ContextGroup) error {
+	for _, group := range groups {
+		first := group.Events[0]
+		stream := first.Account + "/" + first.Region + "/" + first.Group + "/" + first.Stream
+		if p.separate && p.printed && !(group.Continues && stream == p.stream) {
+			if _, err := fmt.Fprintln(p.out, "--"); err != nil {
+				return err
+			}
+		}
+		p.printed = true
+		p.stream = stream
+
+		for _, event := range group.Events {
+			if err := p.output.Encode(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [group]",
+	Short: "Export a time window of a group to compressed JSON Lines files",
+	Long: `Export a time window of a group to compressed JSON Lines files.
+
+The window is downloaded one hour at a time, starting no earlier than the
+creation of the group (e.g. with --since all). A manifest.json file in the
+output directory lists every file with its event count and SHA-256 checksum.
+An interrupted export can be continued with --resume.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: export,
+}
+
+var (
+	exportDir         string
+	exportCompression string
+	exportSplit       string
+	exportMaxSize     int64
+	exportResume      bool
+	exportQuiet       bool
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	exportCmd.Flags().StringVarP(&since, "since", "s", "1h", "Export logs since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	exportCmd.Flags().StringVarP(&until, "until", "u", "now", "Export logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	exportCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	exportCmd.Flags().StringVarP(&exportDir, "dir", "d", "", "Directory the export is written to")
+	exportCmd.Flags().StringVar(&exportCompression, "compression", "gzip", "Compression, one of: gzip, zstd, none")
+	exportCmd.Flags().StringVar(&exportSplit, "split", "hour", "Split files by stream, hour or size")
+	exportCmd.Flags().Int64Var(&exportMaxSize, "max-size", 100<<20, "Uncompressed bytes per file when splitting by size")
+	exportCmd.Flags().BoolVar(&exportResume, "resume", false, "Resume the export in --dir using the settings stored in its manifest")
+	exportCmd.Flags().BoolVar(&exportQuiet, "quiet", false, "Do not print export progress")
+	exportCmd.MarkFlagRequired("dir")
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	svc, err := lib.NewCloudwatchLogsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var exporter *lib.Exporter
+	if exportResume {
+		if len(args) > 0 {
+			return fmt.Errorf("can't set a group with --resume, it is read from the manifest")
+		}
+		exporter, err = lib.ResumeExporter(svc, exportDir)
+		if err != nil {
+			return err
+		}
+		manifest := exporter.Manifest()
+		if manifest.Complete {
+			notify(fmt.Sprintf("export in '%s' is already complete", exportDir))
+			return nil
+		}
+		notify(fmt.Sprintf("resuming export of '%s' with %d chunks done", manifest.Group, len(manifest.ChunksDone)))
+	} else {
+		if len(args) == 0 {
+			return fmt.Errorf("a group is required")
+		}
+
+		start, err := lib.GetTime(since, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to parse time '%s'", since)
+		}
+		end, err := lib.GetTime(until, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to parse time '%s'", until)
+		}
+
+		exporter, err = lib.NewExporter(svc, lib.ExportOptions{
+			Group:       args[0],
+			Prefix:      prefix,
+			Filter:      filterPattern,
+			Start:       start,
+			End:         end,
+			Dir:         exportDir,
+			Compression: exportCompression,
+			Split:       exportSplit,
+			MaxSize:     exportMaxSize,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var progress func(lib.ExportProgress)
+	printed := false
+	if !exportQuiet && (isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd())) {
+		progress = func(p lib.ExportProgress) {
+			fmt.Fprintf(os.Stderr, "\r\033[Kchunks %d/%d, %d events", p.ChunksDone, p.Chunks, p.Events)
+			printed = true
+		}
+	}
+
+	err = exporter.Run(ctx, progress)
+	if printed {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("export interrupted, continue it with --resume --dir %s", exportDir)
+		}
+		return err
+	}
+
+	manifest := exporter.Manifest()
+	notify(fmt.Sprintf("exported %d events to %d files in '%s'", manifest.Events, len(manifest.Files), exportDir))
+	return nil
+}
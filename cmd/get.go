@@ -2,12 +2,17 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 	"syscall"
 	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/pecigonzalo/loro/lib"
 	"github.com/segmentio/events/v2"
 	"github.com/spf13/cobra"
@@ -16,6 +21,8 @@ import (
 const (
 	defaultFormatString = `[ {{ uniquecolor (print .Stream) }} ] {{ .TimeShort }} - {{ .Event.message }}`
 	rawFormatString     = `{{ .PrettyPrint }}`
+	// checkpointInterval is how often --checkpoint progress is saved
+	checkpointInterval = 5 * time.Second
 )
 
 var templateFuncMap = template.FuncMap{
@@ -31,16 +38,51 @@ var templateFuncMap = template.FuncMap{
 
 // getCmd represents the get command
 var getCmd = &cobra.Command{
-	Use:   "get",
-	Short: "Get logs from a group or stream",
-	RunE:  get,
+	Use:     "get [group...]",
+	Aliases: []string{"search"},
+	Short:   "Get logs from one or more groups or streams",
+	Long: `Get logs from one or more groups or streams.
+
+Several groups, or glob patterns such as /ecs/prod-*, can be given at once,
+as well as views defined in the config file (e.g. @payments-errors).
+Their events are merged into a single stream ordered by timestamp. The same
+applies to several regions or accounts given with --targets.
+
+With --archive, events are also stored in a local archive. With --local, the
+parts of the time window already in the archive are read from it and only
+the gaps are fetched from CloudWatch.`,
+	RunE: get,
 }
 
 var (
-	follow        bool
-	prefix        string
-	eventTemplate string
-	raw           bool
+	follow         bool
+	prefix         string
+	eventTemplate  string
+	raw            bool
+	filterPattern  string
+	mergeBuffer    int
+	liveTail       bool
+	outputFormat   string
+	columns        []string
+	whereExpr      string
+	checkpointName string
+	shards         int
+	workers        int
+	archiveEvents  bool
+	localSearch    bool
+	archiveDir     string
+	getTargets     []string
+	multiline      bool
+	multilineStart string
+	multilineLines int
+	multilineWait  time.Duration
+	parserName     string
+	lambdaMode     bool
+	lambdaGroup    bool
+	lambdaSummary  bool
+	contextBefore  int
+	contextAfter   int
+	contextAround  int
 )
 
 func init() {
@@ -52,14 +94,43 @@ func init() {
 	getCmd.Flags().StringVarP(&until, "until", "u", "now", "Fetch logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
 	getCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 10, "Maximum number of streams to fetch from (for prefix search)")
 	getCmd.Flags().BoolVarP(&raw, "raw", "r", false, "Raw JSON output")
+	getCmd.Flags().StringVar(&outputFormat, "output", "text", "Output mode, one of: text (uses --format), "+strings.Join(lib.Encoders(), ", "))
+	getCmd.Flags().StringSliceVar(&columns, "columns", lib.DefaultColumns, "Columns for csv output: time, ingestion_time, group, stream, region, account, id or a dotted path into the event (e.g. request.path)")
+	getCmd.Flags().IntVar(&mergeBuffer, "merge-buffer", lib.DefaultMergeBufferSize, "Maximum number of events held back to keep output from several groups in order")
+	getCmd.Flags().StringVar(&whereExpr, "where", "", "Only show events whose parsed fields match an expression (e.g. 'level in (\"error\",\"warn\") && status >= 500 && path ~ \"^/api\"')")
+	getCmd.Flags().StringVar(&checkpointName, "checkpoint", "", "Persist progress under this name and resume from it on the next run")
+	getCmd.Flags().IntVar(&shards, "shards", 1, "Split the time window into this many shards fetched in parallel (not with --follow)")
+	getCmd.Flags().IntVar(&workers, "workers", lib.DefaultBackfillWorkers, "Maximum number of shards fetched at once")
+	getCmd.Flags().BoolVar(&liveTail, "live", false, "Use a CloudWatch Live Tail session instead of polling when following")
+	getCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	getCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+	getCmd.Flags().BoolVar(&lambdaMode, "lambda", false, "Parse Lambda START/END/REPORT lines and tag every event with its request_id")
+	getCmd.Flags().BoolVar(&lambdaGroup, "lambda-group", false, "Print the events of each Lambda invocation together (implies --lambda)")
+	getCmd.Flags().BoolVar(&lambdaSummary, "lambda-summary", false, "Print duration percentiles, cold starts and memory use of the Lambda invocations on stderr at the end (implies --lambda)")
+	getCmd.Flags().IntVarP(&contextBefore, "before-context", "B", 0, "Show this many events of the same stream before each match (uses GetLogEvents)")
+	getCmd.Flags().IntVarP(&contextAfter, "after-context", "A", 0, "Show this many events of the same stream after each match (uses GetLogEvents, not with --follow)")
+	getCmd.Flags().IntVarP(&contextAround, "context", "C", 0, "Show this many events of the same stream before and after each match")
+	getCmd.Flags().BoolVar(&multiline, "multiline", false, "Join indented lines, such as stack traces, to the previous event of their stream")
+	getCmd.Flags().StringVar(&multilineStart, "multiline-start", "", "Regular expression matching the first line of an event, other lines are joined to the previous event (implies --multiline)")
+	getCmd.Flags().IntVar(&multilineLines, "multiline-max-lines", lib.DefaultMultilineMaxLines, "Maximum number of lines joined into one event")
+	getCmd.Flags().DurationVar(&multilineWait, "multiline-timeout", lib.DefaultMultilineTimeout, "How long an event waits for more lines before it is printed")
+	getCmd.Flags().StringSliceVar(&getTargets, "targets", nil, "Read from several regions or accounts at once, each target is a region, profile@region or profile@ (e.g. eu-west-1,us-east-1)")
+	getCmd.Flags().BoolVar(&archiveEvents, "archive", false, "Store the fetched events in the local archive")
+	getCmd.Flags().BoolVar(&localSearch, "local", false, "Read the parts of the time window held in the local archive from it, fetching only the gaps (implies --archive)")
+	getCmd.Flags().StringVar(&archiveDir, "archive-dir", "", "Directory of the local archive (default is the user cache directory)")
 }
 
 func get(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	group := "/"
+	groupNames := []string{"/"}
+
+	args, err := applyViews(cmd, args)
+	if err != nil {
+		return err
+	}
 
 	if len(args) > 0 {
-		group = args[0]
+		groupNames = args
 	}
 
 	start, err := lib.GetTime(since, time.Now())
@@ -67,43 +138,217 @@ func get(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse time '%s'", since)
 	}
 
+	useUntil, err := untilOrFollow(cmd)
+	if err != nil {
+		return err
+	}
+
 	var end time.Time
-	if cmd.Flags().Lookup("until").Changed {
-		if cmd.Flags().Lookup("follow").Changed {
-			return fmt.Errorf("can't set both --until and --follow")
-		}
+	if useUntil {
 		end, err = lib.GetTime(until, time.Now())
 		if err != nil {
 			return fmt.Errorf("failed to parse time '%s'", until)
 		}
 	}
 
-	lib.SetMaxStreams(100)
+	if liveTail && !follow {
+		return fmt.Errorf("--live can only be used with --follow")
+	}
+
+	if shards > 1 && follow {
+		return fmt.Errorf("can't set both --shards and --follow")
+	}
 
-	logReader, err := lib.NewCloudwatchLogsReader(group, prefix, start, end)
+	if localSearch && liveTail {
+		return fmt.Errorf("can't set both --local and --live")
+	}
+
+	if len(getTargets) > 1 && (archiveEvents || localSearch) {
+		return fmt.Errorf("can't use the local archive with several --targets")
+	}
+
+	contextLines, err := newContextLines(cmd)
 	if err != nil {
 		return err
 	}
 
-	// Try and fetch the group to verify it exists
-	_, err = logReader.GetGroup(ctx)
+	output, err := newEventEncoder(os.Stdout)
 	if err != nil {
 		return err
 	}
 
-	if raw {
-		eventTemplate = rawFormatString
+	var where *lib.Where
+	if whereExpr != "" {
+		where, err = lib.ParseWhere(whereExpr)
+		if err != nil {
+			return err
+		}
 	}
 
-	output, err := template.New("event").Funcs(templateFuncMap).Parse(eventTemplate)
+	parser, err := lib.NewParser(parserName)
 	if err != nil {
 		return err
 	}
+	lambdaMode = lambdaMode || lambdaGroup || lambdaSummary
+	if lambdaMode && parserName != "lambda" {
+		parser = lib.NewLambdaParser(parser)
+	}
+	var summary *lib.LambdaSummary
+	if lambdaSummary {
+		summary = &lib.LambdaSummary{}
+	}
+
+	var multilineOpts *lib.MultilineOptions
+	if multiline || multilineStart != "" {
+		multilineOpts = &lib.MultilineOptions{MaxLines: multilineLines, Timeout: multilineWait}
+		if multilineStart != "" {
+			multilineOpts.Start, err = regexp.Compile(multilineStart)
+			if err != nil {
+				return fmt.Errorf("invalid --multiline-start expression: %w", err)
+			}
+		}
+	}
+
+	var checkpoint *lib.Checkpoint
+	if checkpointName != "" {
+		checkpoint, err = lib.LoadCheckpoint(checkpointName)
+		if err != nil {
+			return err
+		}
+		if checkpoint.Exists() {
+			start = checkpoint.Start()
+			notify(fmt.Sprintf("resuming from checkpoint '%s' at %s", checkpointName, checkpoint.Timestamp.Local().Format(lib.ShortTimeFormat)))
+		}
+		defer func() {
+			if err := checkpoint.Save(); err != nil {
+				notify(fmt.Sprintf("failed to save checkpoint '%s': %s", checkpointName, err))
+			}
+		}()
+	}
+
+	var archive *lib.Archive
+	if archiveEvents || localSearch {
+		archive, err = openArchive()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := archive.Close(); err != nil {
+				notify(fmt.Sprintf("failed to write archive: %s", err))
+			}
+		}()
+	}
+
+	lib.SetMaxStreams(100)
+
+	targets, err := parseTargets(getTargets)
+	if err != nil {
+		return err
+	}
+
+	archived := map[string]bool{}
+	if localSearch {
+		groups, err := archive.Groups()
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			archived[group] = true
+		}
+	}
+
+	progress := newBackfillProgress(os.Stderr)
+	defer progress.done()
+
+	logReaders := []*lib.CloudwatchLogsReader{}
+	for _, target := range targets {
+		svc, err := lib.NewCloudwatchLogsClientWithOptions(ctx, target.options)
+		if err != nil {
+			return err
+		}
+		region := svc.Options().Region
+
+		targetGroups, err := lib.ExpandLogGroups(ctx, svc, groupNames)
+		if err != nil {
+			return target.wrap(err)
+		}
+
+		for _, group := range targetGroups {
+			logReader, err := lib.NewCloudwatchLogsReaderWithSource(svc, group, prefix, start, end)
+			if err != nil {
+				return err
+			}
+
+			if err := logReader.SetFilterPattern(filterPattern); err != nil {
+				return err
+			}
+			logReader.SetLiveTail(liveTail)
+			logReader.SetParser(parser)
+			logReader.SetNotifyFunc(target.notify)
+			logReader.SetOrigin(region, "")
+			if checkpoint != nil {
+				logReader.MarkSeen(checkpoint.EventIDs()...)
+			}
+			if shards > 1 {
+				name := target.name
+				logReader.SetShards(shards, workers)
+				logReader.SetProgressFunc(func(p lib.BackfillProgress) {
+					p.Group = name + p.Group
+					progress.update(p)
+				})
+			}
+			if archive != nil {
+				logReader.SetArchive(archive, localSearch)
+			}
+
+			// Try and fetch the group to verify it exists, unless it is archived
+			if !archived[group] {
+				logGroup, err := logReader.GetGroup(ctx)
+				if err != nil {
+					return target.wrap(err)
+				}
+				logReader.SetOrigin(region, lib.ARNAccount(aws.ToString(logGroup.Arn)))
+			}
+			if contextLines != nil {
+				contextLines.AddReader(logReader)
+			}
+
+			logReaders = append(logReaders, logReader)
+		}
+	}
 
 	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	eventChan := logReader.StreamEvents(ctx, follow)
+	eventChans := make([]<-chan lib.Event, 0, len(logReaders))
+	for _, logReader := range logReaders {
+		eventChans = append(eventChans, logReader.StreamEvents(ctx, follow))
+	}
+	eventChan := lib.MergeEvents(ctx, mergeBuffer, lib.DefaultMergeDelay, eventChans...)
+	if multilineOpts != nil {
+		eventChan = lib.AggregateMultiline(ctx, eventChan, *multilineOpts)
+	}
+	if lambdaMode {
+		eventChan = lib.TrackLambdaInvocations(ctx, eventChan, lib.LambdaOptions{Group: lambdaGroup})
+	}
+
+	var saveTicker <-chan time.Time
+	if checkpoint != nil {
+		t := time.NewTicker(checkpointInterval)
+		defer t.Stop()
+		saveTicker = t.C
+	}
+
+	var printer *contextPrinter
+	var contextTicker <-chan time.Time
+	if contextLines != nil {
+		printer = &contextPrinter{out: os.Stdout, output: output, separate: outputFormat == "text"}
+		if follow {
+			t := time.NewTicker(time.Second)
+			defer t.Stop()
+			contextTicker = t.C
+		}
+	}
 
 	ticker := time.After(7 * time.Second)
 ReadLoop:
@@ -114,30 +359,107 @@ ReadLoop:
 				break ReadLoop
 			}
 
-			err = output.Execute(os.Stdout, event)
-			if err != nil {
-				fmt.Fprint(os.Stdout, err.Error())
-				return err
-			}
-
-			fmt.Fprintf(os.Stdout, "\n")
 			// reset slow log warning timer
 			ticker = time.After(7 * time.Second)
+
+			if summary != nil {
+				summary.Observe(event)
+			}
+
+			if where == nil || where.Match(event) {
+				if contextLines != nil {
+					groups, err := contextLines.Add(ctx, event)
+					if err != nil {
+						return err
+					}
+					err = printer.print(groups)
+				} else {
+					err = output.Encode(event)
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if checkpoint != nil {
+				checkpoint.Observe(event)
+			}
+		case <-contextTicker:
+			if err := printer.print(contextLines.Expire(contextFlushDelay)); err != nil {
+				return err
+			}
+		case <-saveTicker:
+			if err := checkpoint.Save(); err != nil {
+				return fmt.Errorf("failed to save checkpoint '%s': %w", checkpointName, err)
+			}
 		case <-ticker:
-			if !follow {
-				fmt.Fprintf(os.Stdout, "logs are taking a while to load... possibly try a smaller time window")
+			if !follow && shards <= 1 {
+				notify("logs are taking a while to load... possibly try a smaller time window")
 			}
 		}
 	}
 
-	if err := logReader.Error(); err != nil {
-		if err == context.Canceled {
-			return nil
+	if contextLines != nil {
+		if err := printer.print(contextLines.Flush()); err != nil {
+			return err
 		}
+	}
 
-		return err
+	if summary != nil {
+		printLambdaSummary(os.Stderr, summary)
+	}
+
+	for _, logReader := range logReaders {
+		if err := logReader.Error(); err != nil {
+			if errors.Is(err, context.Canceled) {
+				continue
+			}
+
+			return err
+		}
 	}
 
 	return nil
 
 }
+
+// newEventEncoder returns the encoder selected by --output, text output uses
+// the --format template
+func newEventEncoder(w io.Writer) (lib.Encoder, error) {
+	if outputFormat != "text" {
+		if raw {
+			return nil, fmt.Errorf("can't set both --raw and --output %s", outputFormat)
+		}
+		return lib.NewEncoder(outputFormat, w, lib.EncoderOptions{Columns: columns})
+	}
+
+	if raw {
+		eventTemplate = rawFormatString
+	}
+
+	tmpl, err := template.New("event").Funcs(templateFuncMap).Parse(eventTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.NewTemplateEncoder(w, tmpl), nil
+}
+
+// openArchive opens the local archive in --archive-dir or its default location
+func openArchive() (*lib.Archive, error) {
+	dir := archiveDir
+	if dir == "" {
+		var err error
+		dir, err = lib.ArchiveDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lib.OpenArchive(dir)
+}
+
+// notify prints informational messages on stderr so they do not mix with the
+// events on stdout
+func notify(msg string) {
+	fmt.Fprintf(os.Stderr, "loro: %s\n", msg)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+// printLambdaSummary writes the --lambda-summary table
+func printLambdaSummary(out io.Writer, s *lib.LambdaSummary) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "invocations\t%d\n", s.Invocations)
+	if s.Invocations > 0 {
+		fmt.Fprintf(w, "duration p50/p95/p99\t%.2f / %.2f / %.2f ms\n", s.Duration(50), s.Duration(95), s.Duration(99))
+		fmt.Fprintf(w, "max duration\t%.2f ms\n", s.Duration(100))
+		fmt.Fprintf(w, "cold starts\t%d (%.1f%%)\n", s.ColdStarts, float64(s.ColdStarts)/float64(s.Invocations)*100)
+		if s.ColdStarts > 0 {
+			fmt.Fprintf(w, "init duration p50/p99\t%.2f / %.2f ms\n", s.InitDuration(50), s.InitDuration(99))
+		}
+		fmt.Fprintf(w, "max memory used\t%.0f / %.0f MB\n", s.MaxMemoryUsedMB, s.MemorySizeMB)
+	}
+	fmt.Fprintf(w, "timeouts\t%d\n", s.Timeouts)
+	w.Flush()
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+// patternsCmd represents the patterns command
+var patternsCmd = &cobra.Command{
+	Use:   "patterns [group...]",
+	Short: "Group the events of one or more groups into message patterns",
+	Long: `Group the events of one or more groups into message patterns.
+
+Similar messages are clustered into patterns with their variable parts, such
+as numbers, IDs or names, replaced by <*>. Each pattern is listed with its
+number of events and when it was first and last seen.
+
+With --follow, the patterns of the time window are learned first and only
+events starting a new pattern are printed as they arrive. The patterns table
+is printed on exit.`,
+	RunE: patterns,
+}
+
+var (
+	patternSimilarity float64
+	patternDepth      int
+	patternTop        int
+)
+
+func init() {
+	rootCmd.AddCommand(patternsCmd)
+	patternsCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	patternsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log streams and print events starting a new pattern")
+	patternsCmd.Flags().StringVarP(&since, "since", "s", "1h", "Read logs since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	patternsCmd.Flags().StringVarP(&until, "until", "u", "now", "Read logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	patternsCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	patternsCmd.Flags().StringVar(&whereExpr, "where", "", "Only cluster events whose parsed fields match an expression (e.g. 'level == \"error\"')")
+	patternsCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+	patternsCmd.Flags().IntVar(&shards, "shards", 1, "Split the time window into this many shards fetched in parallel (not with --follow)")
+	patternsCmd.Flags().IntVar(&workers, "workers", lib.DefaultBackfillWorkers, "Maximum number of shards fetched at once")
+	patternsCmd.Flags().Float64Var(&patternSimilarity, "similarity", lib.DefaultPatternSimilarity, "Share of tokens (0-1) a message must have in common with a pattern to be part of it")
+	patternsCmd.Flags().IntVar(&patternDepth, "depth", lib.DefaultPatternDepth, "Depth of the pattern tree, messages are routed by their first depth-3 tokens")
+	patternsCmd.Flags().IntVar(&patternTop, "top", 50, "Number of patterns listed, 0 for all")
+}
+
+func patterns(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("at least one group is required")
+	}
+	if patternSimilarity <= 0 || patternSimilarity > 1 {
+		return fmt.Errorf("--similarity must be between 0 and 1")
+	}
+	useUntil, err := untilOrFollow(cmd)
+	if err != nil {
+		return err
+	}
+	if shards > 1 && follow {
+		return fmt.Errorf("can't set both --shards and --follow")
+	}
+
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", since)
+	}
+
+	var end time.Time
+	if useUntil {
+		end, err = lib.GetTime(until, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to parse time '%s'", until)
+		}
+	}
+
+	var where *lib.Where
+	if whereExpr != "" {
+		where, err = lib.ParseWhere(whereExpr)
+		if err != nil {
+			return err
+		}
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	miner := lib.NewPatternMiner(lib.PatternOptions{Depth: patternDepth, Similarity: patternSimilarity})
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	progress := newBackfillProgress(os.Stderr)
+	logReaders, eventChans, err := readGroups(ctx, args, start, end, follow, parser, progress)
+	if err != nil {
+		return err
+	}
+
+	// Events ingested before starting belong to the window being learned
+	started := time.Now()
+	for event := range lib.MergeEvents(ctx, 0, 0, eventChans...) {
+		if where != nil && !where.Match(event) {
+			continue
+		}
+
+		pattern, created := miner.Add(event)
+		if follow && created && event.IngestTime.After(started) {
+			fmt.Printf("[ %s ] %s #%d %s\n", lib.Unique(event.Stream), event.TimeShort(), pattern.ID, pattern.Example)
+		}
+	}
+	progress.done()
+
+	if err := readersError(ctx, logReaders); err != nil {
+		return err
+	}
+	if ctx.Err() != nil && !follow {
+		notify("interrupted, showing the patterns found so far")
+	}
+
+	printPatterns(os.Stdout, miner.Patterns())
+	return nil
+}
+
+// printPatterns writes the patterns table
+func printPatterns(out io.Writer, patterns []*lib.Pattern) {
+	total := len(patterns)
+	if patternTop > 0 && total > patternTop {
+		patterns = patterns[:patternTop]
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEVENTS\tFIRST\tLAST\tPATTERN")
+	for _, p := range patterns {
+		fmt.Fprintf(w, "#%d\t%d\t%s\t%s\t%s\n", p.ID, p.Count,
+			p.FirstSeen.Local().Format(lib.ShortTimeFormat), p.LastSeen.Local().Format(lib.ShortTimeFormat), p.Template())
+	}
+	if total > len(patterns) {
+		fmt.Fprintf(w, "... %d more patterns\n", total-len(patterns))
+	}
+	w.Flush()
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+	"github.com/pecigonzalo/loro/lib"
+)
+
+// backfillProgress prints a single status line with the combined progress of
+// the sharded backfills of every group. Nothing is printed unless the output
+// is a terminal.
+type backfillProgress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	enabled bool
+	printed bool
+	groups  map[string]lib.BackfillProgress
+}
+
+func newBackfillProgress(out *os.File) *backfillProgress {
+	return &backfillProgress{
+		out:     out,
+		enabled: isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd()),
+		groups:  map[string]lib.BackfillProgress{},
+	}
+}
+
+func (p *backfillProgress) update(progress lib.BackfillProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.enabled {
+		return
+	}
+	p.groups[progress.Group] = progress
+
+	names := make([]string, 0, len(p.groups))
+	for name := range p.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total lib.BackfillProgress
+	for _, name := range names {
+		g := p.groups[name]
+		total.Shards += g.Shards
+		total.ShardsDone += g.ShardsDone
+		total.Events += g.Events
+		if g.Elapsed > total.Elapsed {
+			total.Elapsed = g.Elapsed
+		}
+	}
+
+	fmt.Fprintf(p.out, "\r\033[Kshards %d/%d, %d events, %.0f events/s",
+		total.ShardsDone, total.Shards, total.Events, total.Rate())
+	p.printed = true
+}
+
+// done ends the status line
+func (p *backfillProgress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.printed {
+		fmt.Fprintln(p.out)
+	}
+}
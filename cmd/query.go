@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query [group...]",
+	Short: "Run a CloudWatch Logs Insights query over one or more groups",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  query,
+}
+
+var (
+	queryString string
+	queryFile   string
+	queryLimit  int32
+	queryOutput string
+	queryQuiet  bool
+)
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().StringVarP(&queryString, "query", "q", "", "Insights query string (e.g. 'stats count(*) by bin(5m)')")
+	queryCmd.Flags().StringVar(&queryFile, "file", "", "Read the Insights query from a file")
+	queryCmd.Flags().StringVarP(&since, "since", "s", "1h", "Query logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
+	queryCmd.Flags().StringVarP(&until, "until", "u", "now", "Query logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	queryCmd.Flags().Int32VarP(&queryLimit, "limit", "l", 0, "Maximum number of rows to return (default is the service limit)")
+	queryCmd.Flags().StringVar(&queryOutput, "output", "table", "Output format, one of: table, json, csv")
+	queryCmd.Flags().BoolVar(&queryQuiet, "quiet", false, "Do not print query progress")
+}
+
+func query(cmd *cobra.Command, args []string) error {
+	if queryString != "" && queryFile != "" {
+		return fmt.Errorf("can't set both --query and --file")
+	}
+
+	if queryFile != "" {
+		content, err := os.ReadFile(queryFile)
+		if err != nil {
+			return fmt.Errorf("failed to read query file '%s': %w", queryFile, err)
+		}
+		queryString = string(content)
+	}
+
+	if strings.TrimSpace(queryString) == "" {
+		return fmt.Errorf("a query is required, use --query or --file")
+	}
+
+	switch queryOutput {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown output format '%s', expected one of: table, json, csv", queryOutput)
+	}
+
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", since)
+	}
+
+	end, err := lib.GetTime(until, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", until)
+	}
+
+	insightsQuery, err := lib.NewInsightsQuery(args, queryString, start, end)
+	if err != nil {
+		return err
+	}
+	insightsQuery.SetLimit(queryLimit)
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	progress := func(status types.QueryStatus, stats types.QueryStatistics) {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s: %.0f records matched, %.0f records scanned, %s scanned",
+			status, stats.RecordsMatched, stats.RecordsScanned, formatBytes(stats.BytesScanned))
+	}
+	if queryQuiet {
+		progress = nil
+	}
+
+	result, err := insightsQuery.Run(ctx, progress)
+	if !queryQuiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.Status == types.QueryStatusCancelled {
+		notify("query cancelled, showing partial results")
+	}
+
+	switch queryOutput {
+	case "json":
+		return writeQueryJSON(os.Stdout, result)
+	case "csv":
+		return writeQueryCSV(os.Stdout, result)
+	default:
+		return writeQueryTable(os.Stdout, result, notify)
+	}
+}
+
+// writeQueryTable writes the results as a table to out, or reports there are
+// none with notice
+func writeQueryTable(out io.Writer, result *lib.QueryResult, notice func(string)) error {
+	if len(result.Rows) == 0 {
+		notice("no results")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, '\t', 0)
+	fmt.Fprintln(w, strings.Join(result.Fields, "\t"))
+
+	for _, row := range result.Rows {
+		values := make([]string, 0, len(result.Fields))
+		for _, field := range result.Fields {
+			// Keep multi-line values from breaking the table layout
+			values = append(values, strings.ReplaceAll(row[field], "\n", " "))
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func writeQueryJSON(out io.Writer, result *lib.QueryResult) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result.Rows)
+}
+
+func writeQueryCSV(out io.Writer, result *lib.QueryResult) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(result.Fields); err != nil {
+		return err
+	}
+
+	for _, row := range result.Rows {
+		values := make([]string, 0, len(result.Fields))
+		for _, field := range result.Fields {
+			values = append(values, row[field])
+		}
+		if err := w.Write(values); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// formatBytes returns a human readable size
+func formatBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+func TestWriteQueryResults(t *testing.T) {
+	result := &lib.QueryResult{
+		Fields: []string{"@timestamp", "@message"},
+		Rows: []map[string]string{
+			{"@timestamp": "2024-03-01 10:00:00.000", "@message": "multi\nline"},
+			{"@timestamp": "2024-03-01 10:00:01.000"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		write func(*bytes.Buffer) error
+		want  string
+	}{
+		{"table", func(out *bytes.Buffer) error { return writeQueryTable(out, result, func(string) {}) },
+			"@timestamp\t\t\t@message\n2024-03-01 10:00:00.000\t\tmulti line\n2024-03-01 10:00:01.000\t\t\n"},
+		{"csv", func(out *bytes.Buffer) error { return writeQueryCSV(out, result) },
+			"@timestamp,@message\n2024-03-01 10:00:00.000,\"multi\nline\"\n2024-03-01 10:00:01.000,\n"},
+		{"json", func(out *bytes.Buffer) error { return writeQueryJSON(out, &lib.QueryResult{Rows: result.Rows[1:]}) },
+			"[\n  {\n    \"@timestamp\": \"2024-03-01 10:00:01.000\"\n  }\n]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := test.write(out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("got %q, want %q", out.String(), test.want)
+			}
+		})
+	}
+}
+
+// Empty tables are reported through the notice function, not the output
+func TestWriteQueryTableNoResults(t *testing.T) {
+	out := &bytes.Buffer{}
+	notices := []string{}
+	if err := writeQueryTable(out, &lib.QueryResult{}, func(msg string) { notices = append(notices, msg) }); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || len(notices) != 1 || notices[0] != "no results" {
+		t.Errorf("got output %q and notices %v", out.String(), notices)
+	}
+}
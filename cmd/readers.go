@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+// readGroups reads the events of the groups matching args
+func readGroups(ctx context.Context, args []string, start time.Time, end time.Time, follow bool, parser lib.Parser, progress *backfillProgress) ([]*lib.CloudwatchLogsReader, []<-chan lib.Event, error) {
+	svc, err := lib.NewCloudwatchLogsClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groupNames, err := lib.ExpandLogGroups(ctx, svc, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logReaders := []*lib.CloudwatchLogsReader{}
+	eventChans := []<-chan lib.Event{}
+	for _, group := range groupNames {
+		logReader, err := lib.NewCloudwatchLogsReaderWithSource(svc, group, prefix, start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := logReader.SetFilterPattern(filterPattern); err != nil {
+			return nil, nil, err
+		}
+		logReader.SetParser(parser)
+		logReader.SetNotifyFunc(notify)
+		if shards > 1 {
+			logReader.SetShards(shards, workers)
+			logReader.SetProgressFunc(progress.update)
+		}
+
+		logReaders = append(logReaders, logReader)
+		eventChans = append(eventChans, logReader.StreamEvents(ctx, follow))
+	}
+
+	return logReaders, eventChans, nil
+}
+
+// readersError returns the first error of logReaders other than a
+// cancellation or interruption of ctx
+func readersError(ctx context.Context, logReaders []*lib.CloudwatchLogsReader) error {
+	for _, logReader := range logReaders {
+		if err := logReader.Error(); err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ctx.Err()) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pecigonzalo/loro/lib"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -16,11 +19,24 @@ var (
 	until      string
 )
 
+// awsFlags are the root flags selecting how to reach AWS. They can also be set
+// in the config file using the same names, or with LORO_ environment
+// variables (e.g. LORO_ENDPOINT_URL). Other flags are read from the config
+// section of their command, see applyConfigDefaults.
+var awsFlags = []string{"profile", "region", "endpoint-url", "role-arn", "external-id", "mfa-serial"}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:          "loro",
 	Short:        "Loro Only Repeats Output",
 	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfigDefaults(cmd); err != nil {
+			return err
+		}
+		lib.SetAWSOptions(awsOptions())
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -34,6 +50,15 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.loro.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "AWS profile to use from the shared config and credentials files")
+	rootCmd.PersistentFlags().String("region", "", "AWS region to use")
+	rootCmd.PersistentFlags().String("endpoint-url", "", "Send CloudWatch Logs calls to this endpoint (e.g. http://localhost:4566 for LocalStack)")
+	rootCmd.PersistentFlags().String("role-arn", "", "Assume this IAM role with STS")
+	rootCmd.PersistentFlags().String("external-id", "", "External ID used when assuming --role-arn")
+	rootCmd.PersistentFlags().String("mfa-serial", "", "MFA device serial number or ARN required by --role-arn, the code is prompted for")
+	for _, name := range awsFlags {
+		viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name))
+	}
 	listCmd.PersistentFlags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
 	listCmd.PersistentFlags().StringVarP(&since, "since", "s", "1h", "Fetch logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
 	listCmd.PersistentFlags().StringVarP(&until, "until", "u", "now", "Fetch logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
@@ -58,10 +83,37 @@ func initConfig() {
 		viper.SetConfigName(".loro")
 	}
 
+	viper.SetEnvPrefix("loro")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// awsOptions returns the AWS options set with flags, the config file or the
+// environment
+func awsOptions() lib.AWSOptions {
+	return lib.AWSOptions{
+		Profile:      viper.GetString("profile"),
+		Region:       viper.GetString("region"),
+		EndpointURL:  viper.GetString("endpoint-url"),
+		RoleARN:      viper.GetString("role-arn"),
+		ExternalID:   viper.GetString("external-id"),
+		MFASerial:    viper.GetString("mfa-serial"),
+		MFATokenFunc: readMFAToken,
+	}
+}
+
+// readMFAToken prompts for an MFA code on stderr, so the prompt does not end
+// up in the output
+func readMFAToken() (string, error) {
+	fmt.Fprint(os.Stderr, "MFA code: ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read MFA code: %w", err)
 	}
+	return strings.TrimSpace(code), nil
 }
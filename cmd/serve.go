@@ -0,0 +1,489 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/websocket"
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// serveKeepAlive is how often idle tails are pinged so proxies do not
+	// close them
+	serveKeepAlive = 15 * time.Second
+	// serveWriteWait is the time allowed to write a WebSocket message
+	serveWriteWait = 10 * time.Second
+	// serveShutdownWait is the time given to requests to finish on exit
+	serveShutdownWait = 5 * time.Second
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve groups, streams and live tails over HTTP",
+	Long: `Serve groups, streams and live tails over HTTP, so dashboards can show logs
+without AWS credentials.
+
+Endpoints:
+
+  GET /groups?prefix=/ecs/       Groups as JSON
+  GET /groups/<group>/streams    Streams of a group as JSON, the most recently
+                                 active first (e.g. /groups//ecs/api/streams)
+  GET /tail?group=/ecs/api       Events of a group as Server-Sent Events, or
+                                 as WebSocket messages when upgraded
+
+/tail also takes prefix, filter and where parameters, like the flags of get.
+Events are JSON objects as written by get --output jsonl. Clients of the same
+group, prefix and filter share a reader, and new clients get the latest
+events of a running tail first.
+
+With --token, requests must carry it in an "Authorization: Bearer <token>"
+header, or in an access_token parameter for browsers, which can't set headers
+on EventSource and WebSocket connections. The token can also be set with the
+LORO_SERVE_TOKEN environment variable. Without a token, the server only
+listens on loopback addresses and only answers requests addressed to
+localhost or a loopback IP.`,
+	Args: cobra.NoArgs,
+	RunE: serve,
+}
+
+var (
+	serveAddr    string
+	serveToken   string
+	serveOrigins []string
+	serveSince   string
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Bearer token required from clients")
+	serveCmd.Flags().StringSliceVar(&serveOrigins, "allow-origin", nil, "Origins of the pages allowed to call the server from a browser (e.g. https://dashboard.example.com), * for any")
+	serveCmd.Flags().StringVarP(&serveSince, "since", "s", "1m", "How far back a new tail starts reading, relative (e.g. 5m for 5 minutes)")
+	serveCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+}
+
+func serve(cmd *cobra.Command, args []string) error {
+	if serveToken == "" && !isLoopback(serveAddr) {
+		return fmt.Errorf("--token is required to listen on '%s', which is not a loopback address", serveAddr)
+	}
+
+	start, err := lib.GetTime(serveSince, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", serveSince)
+	}
+	lookback := time.Since(start)
+	if lookback < 0 {
+		return fmt.Errorf("--since must be in the past")
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	svc, err := lib.NewCloudwatchLogsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	hub := lib.NewTailHub(ctx, svc)
+	hub.SetParser(parser)
+	hub.SetLookback(lookback)
+	hub.SetNotifyFunc(notify)
+
+	listener, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           newLogServer(svc, hub, listener.Addr().String(), serveToken, serveOrigins),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownWait)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	notify(fmt.Sprintf("listening on http://%s", listener.Addr()))
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// isLoopback reports whether addr only listens on a loopback address
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// logServer serves the HTTP API of serve
+type logServer struct {
+	svc      lib.LogSource
+	hub      *lib.TailHub
+	port     string
+	token    string
+	origins  []string
+	upgrader websocket.Upgrader
+}
+
+// newLogServer returns the server of the API listening on addr
+func newLogServer(svc lib.LogSource, hub *lib.TailHub, addr string, token string, origins []string) *logServer {
+	_, port, _ := net.SplitHostPort(addr)
+	s := &logServer{svc: svc, hub: hub, port: port, token: token, origins: origins}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	return s
+}
+
+// ServeHTTP routes requests by hand, as group names hold slashes that
+// http.ServeMux would redirect away
+func (s *logServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.allowedHost(r.Host) {
+		httpError(w, http.StatusForbidden, "unknown host, use a loopback address or set --token")
+		return
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" && s.allowedOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="loro"`)
+		httpError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/groups":
+		s.groups(w, r)
+	case strings.HasPrefix(path, "/groups/") && strings.HasSuffix(path, "/streams"):
+		s.streams(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/groups/"), "/streams"))
+	case path == "/tail":
+		s.tail(w, r)
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized checks the bearer token of a request, given as a header or an
+// access_token parameter
+func (s *logServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// allowedHost checks the Host of a request. Without a token it must name a
+// loopback address and the port of the server, so pages of other sites can't
+// reach it by rebinding their domain to a loopback address.
+func (s *logServer) allowedHost(host string) bool {
+	if s.token != "" {
+		return true
+	}
+
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), "80"
+	}
+	return port == s.port && isLoopbackHost(name)
+}
+
+func (s *logServer) allowedOrigin(origin string) bool {
+	for _, allowed := range s.origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin accepts WebSocket connections from allowed origins, from pages
+// served by the same host and from clients other than browsers
+func (s *logServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.allowedOrigin(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// groupInfo is the JSON representation of a group
+type groupInfo struct {
+	Name         string    `json:"name"`
+	CreationTime time.Time `json:"creation_time"`
+	StoredBytes  int64     `json:"stored_bytes"`
+}
+
+func (s *logServer) groups(w http.ResponseWriter, r *http.Request) {
+	reader, err := lib.NewCloudwatchLogsReaderWithSource(s.svc, r.URL.Query().Get("prefix"), "", time.Time{}, time.Time{})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	groups, err := reader.ListGroups(r.Context())
+	if err != nil {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	infos := make([]groupInfo, 0, len(groups))
+	for _, group := range groups {
+		infos = append(infos, groupInfo{
+			Name:         aws.ToString(group.LogGroupName),
+			CreationTime: lib.ParseAWSTimestamp(group.CreationTime),
+			StoredBytes:  aws.ToInt64(group.StoredBytes),
+		})
+	}
+	writeJSON(w, infos)
+}
+
+// streamInfo is the JSON representation of a stream
+type streamInfo struct {
+	Name          string    `json:"name"`
+	CreationTime  time.Time `json:"creation_time"`
+	LastEventTime time.Time `json:"last_event_time"`
+}
+
+func (s *logServer) streams(w http.ResponseWriter, r *http.Request, group string) {
+	reader, err := lib.NewCloudwatchLogsReaderWithSource(s.svc, group, r.URL.Query().Get("prefix"), time.Time{}, time.Time{})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	infos := []streamInfo{}
+	streams, err := reader.ListStreams(r.Context())
+	var noStreams *lib.NoLogStreamsError
+	if err != nil && !errors.As(err, &noStreams) {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	for _, stream := range streams {
+		infos = append(infos, streamInfo{
+			Name:          aws.ToString(stream.LogStreamName),
+			CreationTime:  lib.ParseAWSTimestamp(stream.CreationTime),
+			LastEventTime: lib.ParseAWSTimestamp(stream.LastIngestionTime),
+		})
+	}
+	writeJSON(w, infos)
+}
+
+func (s *logServer) tail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := lib.TailOptions{Group: query.Get("group"), Prefix: query.Get("prefix"), Filter: query.Get("filter")}
+	if opts.Group == "" {
+		httpError(w, http.StatusBadRequest, "the group parameter is required")
+		return
+	}
+
+	var where *lib.Where
+	if expr := query.Get("where"); expr != "" {
+		var err error
+		if where, err = lib.ParseWhere(expr); err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	sub, err := s.hub.Subscribe(opts)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer func() {
+		sub.Close()
+		if dropped := sub.Dropped(); dropped > 0 {
+			notify(fmt.Sprintf("dropped %d events of '%s' for a slow client", dropped, opts.Group))
+		}
+	}()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.tailWebSocket(w, r, sub, where)
+	} else {
+		s.tailEventStream(w, r, sub, where)
+	}
+}
+
+// tailEventStream sends the events of sub as Server-Sent Events
+func (s *logServer) tailEventStream(w http.ResponseWriter, r *http.Request, sub *lib.Subscription, where *lib.Where) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(serveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					data, _ := json.Marshal(map[string]string{"error": err.Error()})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+					flusher.Flush()
+				}
+				return
+			}
+			if where == nil || where.Match(event) {
+				data, err := encodeEvent(event)
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return
+				}
+			}
+			// Flush once the events already read are written
+			if len(sub.Events()) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// tailWebSocket sends the events of sub as WebSocket text messages
+func (s *logServer) tailWebSocket(w http.ResponseWriter, r *http.Request, sub *lib.Subscription, where *lib.Where) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader replied with the error
+		return
+	}
+	defer conn.Close()
+
+	// Reading handles control messages and tells when the client is gone
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(serveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(serveWriteWait)); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				if err := sub.Err(); err != nil {
+					message = websocket.FormatCloseMessage(websocket.CloseInternalServerErr, closeReason(err.Error()))
+				}
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(serveWriteWait))
+				return
+			}
+			if where != nil && !where.Match(event) {
+				continue
+			}
+
+			data, err := encodeEvent(event)
+			if err != nil {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(serveWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// encodeEvent returns the JSON Lines record of event without its newline
+func encodeEvent(event lib.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := lib.NewJSONLinesEncoder(&buf).Encode(event); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// closeReason truncates reason to the length allowed in a close message
+func closeReason(reason string) string {
+	const max = 123
+	if len(reason) <= max {
+		return reason
+	}
+	return strings.ToValidUTF8(reason[:max], "")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+// newTestServer returns a server of svc listening on 127.0.0.1:8080
+func newTestServer(t *testing.T, svc lib.LogSource, token string) *logServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newLogServer(svc, lib.NewTailHub(ctx, svc), "127.0.0.1:8080", token, nil)
+}
+
+func TestServeAuthorization(t *testing.T) {
+	svc := lib.NewMemoryLogSource()
+	svc.AddGroup("/ecs/api", time.Now().UnixMilli())
+
+	tests := []struct {
+		name   string
+		token  string
+		host   string
+		target string
+		header string
+		want   int
+	}{
+		{name: "localhost", host: "localhost:8080", want: http.StatusOK},
+		{name: "loopback IPv4", host: "127.0.0.1:8080", want: http.StatusOK},
+		{name: "loopback IPv6", host: "[::1]:8080", want: http.StatusOK},
+		{name: "other host", host: "logs.example.com:8080", want: http.StatusForbidden},
+		{name: "rebound domain", host: "attacker.example.com", want: http.StatusForbidden},
+		{name: "other port", host: "localhost:9090", want: http.StatusForbidden},
+		{name: "default port", host: "localhost", want: http.StatusForbidden},
+		{name: "no token given", token: "secret", host: "logs.example.com", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", host: "logs.example.com", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "basic auth", token: "secret", host: "logs.example.com", header: "Basic secret", want: http.StatusUnauthorized},
+		{name: "bearer token", token: "secret", host: "logs.example.com", header: "Bearer secret", want: http.StatusOK},
+		{name: "token parameter", token: "secret", host: "logs.example.com", target: "/groups?access_token=secret", want: http.StatusOK},
+		{name: "token required on loopback", token: "secret", host: "localhost:8080", want: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := test.target
+			if target == "" {
+				target = "/groups"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Host = test.host
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			rec := httptest.NewRecorder()
+			newTestServer(t, svc, test.token).ServeHTTP(rec, req)
+
+			if rec.Code != test.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.want, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+			if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), `"name":"/ecs/api"`) {
+				t.Errorf("got body %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestServeEventStream(t *testing.T) {
+	svc := lib.NewMemoryLogSource()
+	now := time.Now().Add(-10 * time.Second).UnixMilli()
+	svc.AddEvent("/ecs/api", "api/1", now, `{"level":"info","msg":"started"}`)
+	svc.AddEvent("/ecs/api", "api/1", now+1, `{"level":"error","msg":"failed"}`)
+	svc.AddEvent("/ecs/api", "api/1", now+2, `{"level":"error","msg":"failed again"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewUnstartedServer(nil)
+	server.Config.Handler = newLogServer(svc, lib.NewTailHub(ctx, svc), server.Listener.Addr().String(), "", nil)
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/tail?group=/ecs/api&where=level+%3D%3D+%27error%27", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// Every event is a data line followed by a blank line
+	lines := bufio.NewScanner(resp.Body)
+	for _, want := range []string{"failed", "failed again"} {
+		if !lines.Scan() {
+			t.Fatalf("stream ended: %v", lines.Err())
+		}
+		data, ok := strings.CutPrefix(lines.Text(), "data: ")
+		if !ok {
+			t.Fatalf("got line %q, want a data line", lines.Text())
+		}
+		var record struct {
+			Group string                 `json:"group"`
+			Event map[string]interface{} `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Group != "/ecs/api" || record.Event["msg"] != want {
+			t.Errorf("got %s, want %s", data, want)
+		}
+		if !lines.Scan() || lines.Text() != "" {
+			t.Errorf("got %q after the event, want a blank line", lines.Text())
+		}
+	}
+}
+
+// A tail that fails ends with an error event
+func TestServeEventStreamError(t *testing.T) {
+	svc := lib.NewMemoryLogSource()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := httptest.NewUnstartedServer(nil)
+	server.Config.Handler = newLogServer(svc, lib.NewTailHub(ctx, svc), server.Listener.Addr().String(), "", nil)
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/tail?group=/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "event: error\ndata: {\"error\":\"ResourceNotFoundException: The specified log group does not exist.\"}\n\n"
+	if string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
+
+func TestServeTailErrors(t *testing.T) {
+	server := newTestServer(t, lib.NewMemoryLogSource(), "")
+	for target, want := range map[string]int{
+		"/tail":                         http.StatusBadRequest,
+		"/tail?group=g&where=level+%3D": http.StatusBadRequest,
+		"/unknown":                      http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = "localhost:8080"
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != want || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: got status %d with %s, want %d", target, rec.Code, rec.Header().Get("Content-Type"), want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// histogramWidth is the width of the longest histogram bar
+	histogramWidth = 50
+	// sparklineWidth is the maximum width of the trend column
+	sparklineWidth = 40
+)
+
+var (
+	barBlocks       = []rune(" ▏▎▍▌▋▊▉█")
+	sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats [group...]",
+	Short: "Show the event volume of one or more groups over time",
+	Long: `Show the event volume of one or more groups over time.
+
+Reads the time window like get and prints a histogram of the number of events
+per time bucket, the events and bytes of each stream and the most frequent
+messages. With --by, events are also counted by the value of a parsed field
+(e.g. --by level).`,
+	RunE: stats,
+}
+
+var (
+	statsBucket time.Duration
+	statsTop    int
+	statsBy     string
+)
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	statsCmd.Flags().StringVarP(&since, "since", "s", "1h", "Count logs since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	statsCmd.Flags().StringVarP(&until, "until", "u", "now", "Count logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	statsCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	statsCmd.Flags().StringVar(&whereExpr, "where", "", "Only count events whose parsed fields match an expression (e.g. 'status >= 500')")
+	statsCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+	statsCmd.Flags().IntVar(&shards, "shards", 1, "Split the time window into this many shards fetched in parallel")
+	statsCmd.Flags().IntVar(&workers, "workers", lib.DefaultBackfillWorkers, "Maximum number of shards fetched at once")
+	statsCmd.Flags().DurationVar(&statsBucket, "bucket", 0, "Size of the histogram buckets (default picks one giving about 60 buckets)")
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "Number of streams, values and messages listed")
+	statsCmd.Flags().StringVar(&statsBy, "by", "", "Also count events by the value of a parsed field (e.g. level or request.path)")
+}
+
+func stats(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("at least one group is required")
+	}
+
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", since)
+	}
+	end, err := lib.GetTime(until, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", until)
+	}
+
+	var where *lib.Where
+	if whereExpr != "" {
+		where, err = lib.ParseWhere(whereExpr)
+		if err != nil {
+			return err
+		}
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	summary, err := lib.NewStats(lib.StatsOptions{Start: start, End: end, Bucket: statsBucket, GroupBy: statsBy})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	progress := newBackfillProgress(os.Stderr)
+	logReaders, eventChans, err := readGroups(ctx, args, start, end, false, parser, progress)
+	if err != nil {
+		return err
+	}
+
+	// Order does not matter, a zero buffer merges events as they come
+	for event := range lib.MergeEvents(ctx, 0, 0, eventChans...) {
+		if where == nil || where.Match(event) {
+			summary.Observe(event)
+		}
+	}
+	progress.done()
+
+	if err := readersError(ctx, logReaders); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		notify("interrupted, showing the events read so far")
+	}
+
+	printStats(os.Stdout, summary)
+	return nil
+}
+
+// printStats writes the histogram and tables of stats
+func printStats(out io.Writer, s *lib.Stats) {
+	layout := statsTimeLayout(s)
+	fmt.Fprintf(out, "%d events, %s, from %s to %s in %s buckets\n\n", s.Events, formatBytes(float64(s.Bytes)),
+		s.Start.Local().Format(layout), s.End.Local().Format(layout), s.Bucket)
+
+	histogram := s.Histogram()
+	max := 0
+	for _, count := range histogram {
+		if count > max {
+			max = count
+		}
+	}
+	for i, count := range histogram {
+		t := s.Start.Add(time.Duration(i) * s.Bucket)
+		fmt.Fprintf(out, "%s %s %d\n", t.Local().Format(layout), bar(count, max, histogramWidth), count)
+	}
+
+	// Show the group of each stream when reading several
+	streams := s.Streams()
+	groups := false
+	for _, c := range streams {
+		groups = groups || c.Group != streams[0].Group
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w)
+	if groups {
+		fmt.Fprint(w, "GROUP\t")
+	}
+	fmt.Fprintln(w, "STREAM\tEVENTS\tBYTES\tTREND")
+	for _, c := range limitCounts(streams) {
+		if groups {
+			fmt.Fprintf(w, "%s\t", c.Group)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Name, c.Events, formatBytes(float64(c.Bytes)), sparkline(c.Buckets, sparklineWidth))
+	}
+	printMore(w, len(streams), "streams")
+
+	if statsBy != "" {
+		fmt.Fprintf(w, "\n%s\tEVENTS\tBYTES\tTREND\n", strings.ToUpper(statsBy))
+		values := s.Values()
+		for _, c := range limitCounts(values) {
+			name := c.Name
+			if name == "" {
+				name = "(none)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, c.Events, formatBytes(float64(c.Bytes)), sparkline(c.Buckets, sparklineWidth))
+		}
+		printMore(w, len(values), "values")
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENTS\tMESSAGE")
+	for _, m := range s.TopMessages(statsTop) {
+		fmt.Fprintf(w, "%d\t%s\n", m.Events, m.Message)
+	}
+	w.Flush()
+}
+
+func limitCounts(counts []lib.StatsCount) []lib.StatsCount {
+	if statsTop > 0 && len(counts) > statsTop {
+		return counts[:statsTop]
+	}
+	return counts
+}
+
+func printMore(w io.Writer, total int, what string) {
+	if statsTop > 0 && total > statsTop {
+		fmt.Fprintf(w, "... %d more %s\n", total-statsTop, what)
+	}
+}
+
+// statsTimeLayout returns the timestamp layout fitting the bucket size and
+// window of s
+func statsTimeLayout(s *lib.Stats) string {
+	switch {
+	case s.Bucket >= 24*time.Hour:
+		return "2006-01-02"
+	case s.End.Sub(s.Start) > 24*time.Hour:
+		return "01-02 15:04"
+	case s.Bucket < time.Minute:
+		return "15:04:05"
+	default:
+		return "15:04"
+	}
+}
+
+// bar returns a horizontal bar of value relative to max, using eighth blocks
+func bar(value int, max int, width int) string {
+	if max == 0 {
+		return strings.Repeat(" ", width)
+	}
+	eighths := value * width * 8 / max
+	if eighths == 0 && value > 0 {
+		eighths = 1
+	}
+
+	b := strings.Repeat(string(barBlocks[8]), eighths/8)
+	if eighths%8 > 0 {
+		b += string(barBlocks[eighths%8])
+	}
+	return b + strings.Repeat(" ", width-len([]rune(b)))
+}
+
+// sparkline returns a sparkline of values, adding up neighbouring values when
+// there are more than width
+func sparkline(values []int, width int) string {
+	per := (len(values) + width - 1) / width
+	if per < 1 {
+		per = 1
+	}
+	columns := make([]int, 0, width)
+	max := 0
+	for i := 0; i < len(values); i += per {
+		sum := 0
+		for j := i; j < i+per && j < len(values); j++ {
+			sum += values[j]
+		}
+		columns = append(columns, sum)
+		if sum > max {
+			max = sum
+		}
+	}
+
+	var b strings.Builder
+	for _, value := range columns {
+		if value == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(sparklineBlocks[value*(len(sparklineBlocks)-1)/max])
+	}
+	return strings.TrimRight(b.String(), " ")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+// target is a region and/or profile to read logs from
+type target struct {
+	// name is empty for the default target, or the target as given
+	name    string
+	options lib.AWSOptions
+}
+
+// parseTargets turns --targets values (region, profile@region, profile@ or
+// @region) into AWS options on top of the global ones. Without any, the default target
+// is returned.
+func parseTargets(values []string) ([]target, error) {
+	base := awsOptions()
+	if len(values) == 0 {
+		return []target{{options: base}}, nil
+	}
+
+	targets := make([]target, 0, len(values))
+	seen := map[string]bool{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || value == "@" {
+			return nil, fmt.Errorf("invalid empty target in '%s'", strings.Join(values, ","))
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+
+		options := base
+		if profile, region, ok := strings.Cut(value, "@"); ok {
+			// @region keeps the global profile
+			if profile != "" {
+				options.Profile = profile
+			}
+			if region != "" {
+				options.Region = region
+			}
+		} else {
+			options.Region = value
+		}
+		targets = append(targets, target{name: value, options: options})
+	}
+
+	return targets, nil
+}
+
+// notify prints a notice about the target
+func (t target) notify(msg string) {
+	if t.name != "" {
+		msg = t.name + ": " + msg
+	}
+	notify(msg)
+}
+
+// wrap prefixes err with the target name
+func (t target) wrap(err error) error {
+	if t.name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", t.name, err)
+}
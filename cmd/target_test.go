@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseTargets(t *testing.T) {
+	viper.Set("profile", "default")
+	viper.Set("region", "us-east-1")
+	defer viper.Set("profile", "")
+	defer viper.Set("region", "")
+
+	tests := []struct {
+		values  []string
+		want    string
+		wantErr string
+	}{
+		{values: nil, want: "[:default/us-east-1]"},
+		{values: []string{"eu-west-1"}, want: "[eu-west-1:default/eu-west-1]"},
+		{values: []string{"prod@eu-west-1"}, want: "[prod@eu-west-1:prod/eu-west-1]"},
+		{values: []string{"prod@"}, want: "[prod@:prod/us-east-1]"},
+		{values: []string{"@eu-west-1"}, want: "[@eu-west-1:default/eu-west-1]"},
+		{values: []string{" eu-west-1", "eu-west-1", "staging@"}, want: "[eu-west-1:default/eu-west-1 staging@:staging/us-east-1]"},
+		{values: []string{"eu-west-1", ""}, wantErr: "invalid empty target"},
+		{values: []string{"@"}, wantErr: "invalid empty target"},
+	}
+	for _, test := range tests {
+		targets, err := parseTargets(test.values)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%q: got error %v, want %s", test.values, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, target := range targets {
+			got = append(got, fmt.Sprintf("%s:%s/%s", target.name, target.options.Profile, target.options.Region))
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%q: got %v, want %s", test.values, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,923 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// uiMaxEvents is the number of events kept by the events pane, older
+	// events are dropped
+	uiMaxEvents = 10000
+	// uiTrimEvents is the number of events dropped at once when the pane is
+	// full, so events are not moved on every new one
+	uiTrimEvents = uiMaxEvents / 10
+	// uiAllStreams is the entry of the streams list reading every stream
+	uiAllStreams = "(all streams)"
+)
+
+// uiCmd represents the ui command
+var uiCmd = &cobra.Command{
+	Use:   "ui [group]",
+	Short: "Browse groups, streams and events interactively",
+	Long: `Browse groups, streams and events interactively.
+
+Pick a group from the list, filtered as you type, then one of its streams or
+all of them to follow their events. Starting with a group skips the group
+list.
+
+Keys of the events pane:
+
+  up/down, pgup/pgdn  Move the selection, end follows new events again
+  enter               Show the selected event as JSON
+  /, n, N             Search and highlight, go to the next or previous match
+  space               Pause and resume
+  esc                 Go back to the streams
+  q, ctrl+c           Quit`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: ui,
+}
+
+var uiSince string
+
+func init() {
+	rootCmd.AddCommand(uiCmd)
+	uiCmd.Flags().StringVarP(&uiSince, "since", "s", "15m", "Show the events of a stream since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	uiCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	uiCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+}
+
+func ui(cmd *cobra.Command, args []string) error {
+	if _, err := lib.GetTime(uiSince, time.Now()); err != nil {
+		return fmt.Errorf("failed to parse time '%s'", uiSince)
+	}
+	if err := lib.ValidateFilterPattern(filterPattern); err != nil {
+		return err
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGTERM)
+	defer cancel()
+
+	svc, err := lib.NewCloudwatchLogsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+
+	group := ""
+	if len(args) > 0 {
+		group = args[0]
+	}
+	return newBrowser(screen, svc, parser).run(ctx, group)
+}
+
+// browserView is a screen of the UI
+type browserView int
+
+const (
+	viewGroups browserView = iota
+	viewStreams
+	viewEvents
+)
+
+// browser is the state of the interactive UI. It is only changed by the
+// goroutine running it, background requests hand their results over through
+// the results channel.
+type browser struct {
+	screen tcell.Screen
+	svc    lib.LogSource
+	parser lib.Parser
+
+	view    browserView
+	groups  uiList
+	streams uiList
+	group   string
+	pane    eventPane
+
+	results chan func()
+	loading string
+	status  string
+	done    bool
+}
+
+func newBrowser(screen tcell.Screen, svc lib.LogSource, parser lib.Parser) *browser {
+	return &browser{
+		screen:  screen,
+		svc:     svc,
+		parser:  parser,
+		results: make(chan func()),
+	}
+}
+
+// run shows the UI until it is quit or ctx is done, starting with the streams
+// of group if given
+func (b *browser) run(ctx context.Context, group string) error {
+	keys := make(chan tcell.Event)
+	quit := make(chan struct{})
+	go b.screen.ChannelEvents(keys, quit)
+	defer close(quit)
+	defer b.pane.close()
+
+	if group != "" {
+		b.openGroup(ctx, group)
+	} else {
+		b.loadGroups(ctx)
+	}
+
+	for !b.done {
+		b.draw()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-keys:
+			b.handle(ctx, ev)
+		case update := <-b.results:
+			update()
+		case event, ok := <-b.pane.ch:
+			b.receive(event, ok)
+		}
+	}
+
+	return nil
+}
+
+// async runs fetch in the background and the update it returns in the UI
+// goroutine
+func (b *browser) async(ctx context.Context, fetch func() func()) {
+	go func() {
+		update := fetch()
+		select {
+		case b.results <- update:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (b *browser) loadGroups(ctx context.Context) {
+	b.view = viewGroups
+	b.loading = "Loading groups..."
+	b.async(ctx, func() func() {
+		reader, err := lib.NewCloudwatchLogsReaderWithSource(b.svc, "", "", time.Time{}, time.Time{})
+		if err != nil {
+			return func() { b.loading, b.status = "", err.Error() }
+		}
+		groups, err := reader.ListGroups(ctx)
+
+		return func() {
+			b.loading = ""
+			if err != nil {
+				b.status = err.Error()
+				return
+			}
+
+			names := make([]string, 0, len(groups))
+			details := make([]string, 0, len(groups))
+			for _, group := range groups {
+				names = append(names, aws.ToString(group.LogGroupName))
+				details = append(details, formatBytes(float64(aws.ToInt64(group.StoredBytes))))
+			}
+			b.groups.set(names, details)
+		}
+	})
+}
+
+// openGroup shows the streams of group, the most recently active first
+func (b *browser) openGroup(ctx context.Context, group string) {
+	b.view = viewStreams
+	b.group = group
+	b.streams.set(nil, nil)
+	b.streams.filter = ""
+	b.loading = "Loading streams..."
+	b.async(ctx, func() func() {
+		reader, err := lib.NewCloudwatchLogsReaderWithSource(b.svc, group, "", time.Time{}, time.Time{})
+		if err != nil {
+			return func() { b.loading, b.status = "", err.Error() }
+		}
+		streams, err := reader.ListStreams(ctx)
+
+		return func() {
+			// The user may have moved on to another group
+			if b.group != group {
+				return
+			}
+			b.loading = ""
+			if err != nil {
+				b.status = err.Error()
+				return
+			}
+
+			names := []string{uiAllStreams}
+			details := []string{""}
+			for _, stream := range streams {
+				last := lib.ParseAWSTimestamp(stream.LastIngestionTime)
+				names = append(names, aws.ToString(stream.LogStreamName))
+				details = append(details, fmt.Sprintf("%s (%s ago)", last.Local().Format(lib.ShortTimeFormat), time.Since(last).Round(time.Second)))
+			}
+			b.streams.set(names, details)
+		}
+	})
+}
+
+// openEvents follows the events of a stream of the group, or of all of them
+// when stream is empty
+func (b *browser) openEvents(ctx context.Context, stream string) {
+	start, err := lib.GetTime(uiSince, time.Now())
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+
+	reader, err := lib.NewCloudwatchLogsReaderWithSource(b.svc, b.group, stream, start, time.Time{})
+	if err == nil {
+		err = reader.SetFilterPattern(filterPattern)
+	}
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	reader.SetParser(b.parser)
+
+	readerCtx, cancel := context.WithCancel(ctx)
+	reader.SetNotifyFunc(func(msg string) {
+		select {
+		case b.results <- func() { b.status = msg }:
+		case <-readerCtx.Done():
+		}
+	})
+
+	b.pane = eventPane{
+		stream: stream,
+		follow: true,
+		reader: reader,
+		ch:     reader.StreamEvents(readerCtx, true),
+		cancel: cancel,
+	}
+	b.view = viewEvents
+}
+
+// receive adds an event read by the events pane, with any others already
+// waiting so the screen is drawn once for all of them
+func (b *browser) receive(event lib.Event, ok bool) {
+	for ok {
+		b.pane.add(event)
+
+		select {
+		case event, ok = <-b.pane.ch:
+			continue
+		default:
+		}
+		return
+	}
+
+	b.pane.ch = nil
+	if err := b.pane.reader.Error(); err != nil && !errors.Is(err, context.Canceled) {
+		b.status = err.Error()
+	}
+}
+
+func (b *browser) handle(ctx context.Context, ev tcell.Event) {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		b.screen.Sync()
+	case *tcell.EventKey:
+		b.status = ""
+		if ev.Key() == tcell.KeyCtrlC {
+			b.done = true
+			return
+		}
+
+		switch b.view {
+		case viewGroups:
+			b.handleList(ev, &b.groups, func(name string) { b.openGroup(ctx, name) }, func() { b.done = true })
+		case viewStreams:
+			b.handleList(ev, &b.streams, func(name string) {
+				if name == uiAllStreams {
+					name = ""
+				}
+				b.openEvents(ctx, name)
+			}, func() {
+				b.group = ""
+				if len(b.groups.names) == 0 {
+					b.loadGroups(ctx)
+				}
+				b.view = viewGroups
+			})
+		case viewEvents:
+			b.handleEvents(ev)
+		}
+	}
+}
+
+// handleList handles the keys of a list, calling open with the selected entry
+// on enter and back on escape
+func (b *browser) handleList(ev *tcell.EventKey, list *uiList, open func(string), back func()) {
+	height := b.contentHeight()
+
+	switch ev.Key() {
+	case tcell.KeyUp:
+		list.move(-1)
+	case tcell.KeyDown:
+		list.move(1)
+	case tcell.KeyPgUp:
+		list.move(-height)
+	case tcell.KeyPgDn:
+		list.move(height)
+	case tcell.KeyHome:
+		list.move(-len(list.names))
+	case tcell.KeyEnd:
+		list.move(len(list.names))
+	case tcell.KeyEnter:
+		if name, ok := list.selected(); ok {
+			open(name)
+		}
+	case tcell.KeyEscape:
+		if list.filter != "" {
+			list.setFilter("")
+		} else {
+			back()
+		}
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if r := []rune(list.filter); len(r) > 0 {
+			list.setFilter(string(r[:len(r)-1]))
+		}
+	case tcell.KeyCtrlU:
+		list.setFilter("")
+	case tcell.KeyRune:
+		list.setFilter(list.filter + string(ev.Rune()))
+	}
+}
+
+func (b *browser) handleEvents(ev *tcell.EventKey) {
+	p := &b.pane
+	height := b.contentHeight()
+
+	if p.searching {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			p.searching = false
+			p.search = p.query
+			if p.search != "" {
+				// Following, the latest match is the most relevant
+				dir := 1
+				if p.follow {
+					dir = -1
+				}
+				if !p.find(dir, true) {
+					b.status = fmt.Sprintf("Pattern not found: %s", p.search)
+				}
+			}
+		case tcell.KeyEscape:
+			p.searching = false
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if r := []rune(p.query); len(r) > 0 {
+				p.query = string(r[:len(r)-1])
+			}
+		case tcell.KeyCtrlU:
+			p.query = ""
+		case tcell.KeyRune:
+			p.query += string(ev.Rune())
+		}
+		return
+	}
+
+	if p.expanded {
+		switch ev.Key() {
+		case tcell.KeyUp:
+			p.detailOffset--
+		case tcell.KeyDown:
+			p.detailOffset++
+		case tcell.KeyPgUp:
+			p.detailOffset -= height
+		case tcell.KeyPgDn:
+			p.detailOffset += height
+		case tcell.KeyEnter, tcell.KeyEscape:
+			p.expanded = false
+		case tcell.KeyRune:
+			switch ev.Rune() {
+			case 'k':
+				p.detailOffset--
+			case 'j':
+				p.detailOffset++
+			case 'q':
+				p.expanded = false
+			}
+		}
+		p.detailOffset = clampIndex(p.detailOffset, len(strings.Split(p.detail.PrettyPrint(), "\n"))-height+1)
+		return
+	}
+
+	switch ev.Key() {
+	case tcell.KeyUp:
+		p.move(-1)
+	case tcell.KeyDown:
+		p.move(1)
+	case tcell.KeyPgUp:
+		p.move(-height)
+	case tcell.KeyPgDn:
+		p.move(height)
+	case tcell.KeyHome:
+		p.move(-len(p.events))
+	case tcell.KeyEnd:
+		p.move(len(p.events))
+	case tcell.KeyEnter:
+		if len(p.events) > 0 {
+			// The selection stays on the event shown while new ones arrive
+			p.follow = false
+			p.expanded = true
+			p.detail = p.events[p.cursor]
+			p.detailOffset = 0
+		}
+	case tcell.KeyEscape:
+		if p.search != "" {
+			p.search = ""
+			return
+		}
+		p.close()
+		b.view = viewStreams
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			b.done = true
+		case 'k':
+			p.move(-1)
+		case 'j':
+			p.move(1)
+		case 'g':
+			p.move(-len(p.events))
+		case 'G':
+			p.move(len(p.events))
+		case ' ', 'p':
+			p.togglePause()
+		case '/':
+			p.searching = true
+			p.query = ""
+		case 'n', 'N':
+			dir := 1
+			if ev.Rune() == 'N' {
+				dir = -1
+			}
+			if p.search != "" && !p.find(dir, false) {
+				b.status = fmt.Sprintf("Pattern not found: %s", p.search)
+			}
+		}
+	}
+}
+
+// contentHeight is the number of rows between the title and the footer
+func (b *browser) contentHeight() int {
+	_, height := b.screen.Size()
+	if height < 3 {
+		return 1
+	}
+	return height - 2
+}
+
+var (
+	uiTitleStyle     = tcell.StyleDefault.Reverse(true)
+	uiDimStyle       = tcell.StyleDefault.Dim(true)
+	uiSelectedStyle  = tcell.StyleDefault.Reverse(true)
+	uiHighlightStyle = tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+	uiErrorStyle     = tcell.StyleDefault.Foreground(tcell.ColorRed)
+	uiStreamColors   = []tcell.Color{tcell.ColorGreen, tcell.ColorBlue, tcell.ColorPurple, tcell.ColorTeal, tcell.ColorOlive, tcell.ColorFuchsia}
+)
+
+func (b *browser) draw() {
+	b.screen.Clear()
+	width, height := b.screen.Size()
+	content := b.contentHeight()
+
+	title := " loro"
+	switch b.view {
+	case viewStreams:
+		title += " › " + b.group
+	case viewEvents:
+		stream := b.pane.stream
+		if stream == "" {
+			stream = uiAllStreams
+		}
+		title += " › " + b.group + " › " + stream
+	}
+	fill(b.screen, 0, width, uiTitleStyle)
+	drawText(b.screen, 0, 0, width, title, uiTitleStyle, "")
+	info := b.info()
+	drawText(b.screen, clampIndex(width-runewidth.StringWidth(info)-1, width), 0, width, info, uiTitleStyle, "")
+
+	switch {
+	case b.loading != "":
+		drawText(b.screen, 1, 1, width, b.loading, uiDimStyle, "")
+	case b.view == viewGroups:
+		b.drawList(&b.groups, 1, content, width)
+	case b.view == viewStreams:
+		b.drawList(&b.streams, 1, content, width)
+	case b.pane.expanded:
+		b.drawDetail(1, content, width)
+	default:
+		b.drawEvents(1, content, width)
+	}
+
+	footer, style := b.footer(), uiDimStyle
+	if b.status != "" {
+		footer, style = b.status, uiErrorStyle
+	}
+	drawText(b.screen, 0, height-1, width, footer, style, "")
+	b.screen.Show()
+}
+
+// info is the summary shown on the right of the title
+func (b *browser) info() string {
+	switch b.view {
+	case viewGroups:
+		return fmt.Sprintf("%d/%d groups", len(b.groups.visible), len(b.groups.names))
+	case viewStreams:
+		return fmt.Sprintf("%d streams", clampIndex(len(b.streams.names)-1, len(b.streams.names)))
+	}
+
+	p := b.pane
+	info := fmt.Sprintf("%d events", len(p.events))
+	switch {
+	case p.paused:
+		info += fmt.Sprintf(" | PAUSED, %d new", len(p.held))
+	case p.ch == nil:
+		info += " | done"
+	case p.follow:
+		info += " | following"
+	}
+	if p.search != "" {
+		info += " | /" + p.search
+	}
+	return info
+}
+
+func (b *browser) footer() string {
+	switch {
+	case b.view == viewGroups && b.groups.filter != "":
+		return "Filter: " + b.groups.filter
+	case b.view == viewGroups:
+		return "Type to filter  ↑↓ move  enter streams  esc quit"
+	case b.view == viewStreams && b.streams.filter != "":
+		return "Filter: " + b.streams.filter
+	case b.view == viewStreams:
+		return "Type to filter  ↑↓ move  enter events  esc groups"
+	case b.pane.searching:
+		return "/" + b.pane.query
+	case b.pane.expanded:
+		return "↑↓ scroll  esc close"
+	}
+	return "↑↓ move  end follow  enter json  / search  n/N next/prev  space pause  esc streams  q quit"
+}
+
+func (b *browser) drawList(list *uiList, top int, height int, width int) {
+	list.scroll(height)
+
+	// Align the details of the visible entries, names take up to half the
+	// width
+	column := 0
+	for row := 0; row < height && list.offset+row < len(list.visible); row++ {
+		if w := runewidth.StringWidth(list.names[list.visible[list.offset+row]]); w > column {
+			column = w
+		}
+	}
+	if column > width/2 {
+		column = width / 2
+	}
+	column += 3
+
+	for row := 0; row < height && list.offset+row < len(list.visible); row++ {
+		ix := list.visible[list.offset+row]
+		style, detail := tcell.StyleDefault, uiDimStyle
+		if list.offset+row == list.cursor {
+			style, detail = uiSelectedStyle, uiSelectedStyle
+			fill(b.screen, top+row, width, style)
+		}
+		drawText(b.screen, 1, top+row, column, list.names[ix], style, list.filter)
+		drawText(b.screen, column+1, top+row, width, list.details[ix], detail, "")
+	}
+
+	if len(list.visible) == 0 && len(list.names) > 0 {
+		drawText(b.screen, 1, top, width, "No matches", uiDimStyle, "")
+	}
+}
+
+func (b *browser) drawEvents(top int, height int, width int) {
+	p := &b.pane
+	p.scroll(height)
+
+	for row := 0; row < height && p.offset+row < len(p.events); row++ {
+		ix := p.offset + row
+		event := p.events[ix]
+
+		base := tcell.StyleDefault
+		if ix == p.cursor {
+			base = uiSelectedStyle
+			fill(b.screen, top+row, width, base)
+		}
+
+		x := drawText(b.screen, 0, top+row, width, event.TimeShort()+" ", base.Dim(ix != p.cursor), "")
+		if p.stream == "" {
+			x = drawText(b.screen, x, top+row, width, event.Stream+" ", base.Foreground(streamColor(event.Stream)), "")
+		}
+		drawText(b.screen, x, top+row, width, eventLine(event), base, p.search)
+	}
+
+	if len(p.events) == 0 {
+		drawText(b.screen, 1, top, width, "Waiting for events...", uiDimStyle, "")
+	}
+}
+
+func (b *browser) drawDetail(top int, height int, width int) {
+	p := &b.pane
+	lines := strings.Split(p.detail.PrettyPrint(), "\n")
+	for row := 0; row < height && p.detailOffset+row < len(lines); row++ {
+		drawText(b.screen, 0, top+row, width, lines[p.detailOffset+row], tcell.StyleDefault, p.search)
+	}
+}
+
+// uiList is a list of names filtered as the user types
+type uiList struct {
+	names   []string
+	details []string
+	filter  string
+	// visible holds the positions of the names matching the filter
+	visible []int
+	cursor  int
+	offset  int
+}
+
+func (l *uiList) set(names []string, details []string) {
+	l.names, l.details = names, details
+	l.setFilter(l.filter)
+}
+
+func (l *uiList) setFilter(filter string) {
+	l.filter = filter
+	l.visible = l.visible[:0]
+	for i, name := range l.names {
+		if containsFold(name, filter) {
+			l.visible = append(l.visible, i)
+		}
+	}
+	l.cursor, l.offset = 0, 0
+}
+
+func (l *uiList) move(n int) {
+	l.cursor = clampIndex(l.cursor+n, len(l.visible))
+}
+
+func (l *uiList) selected() (string, bool) {
+	if len(l.visible) == 0 {
+		return "", false
+	}
+	return l.names[l.visible[l.cursor]], true
+}
+
+// scroll keeps the cursor in the height rows shown
+func (l *uiList) scroll(height int) {
+	l.offset = scrollOffset(l.offset, l.cursor, height)
+}
+
+// eventPane holds the events of the streams being followed
+type eventPane struct {
+	stream string
+	ch     <-chan lib.Event
+	reader *lib.CloudwatchLogsReader
+	cancel context.CancelFunc
+
+	events []lib.Event
+	cursor int
+	offset int
+	// follow keeps the latest event selected
+	follow bool
+	paused bool
+	// held are the events received while paused
+	held []lib.Event
+
+	searching bool
+	query     string
+	search    string
+	expanded  bool
+	// detail is the event shown as JSON when expanded
+	detail       lib.Event
+	detailOffset int
+}
+
+// close stops reading events
+func (p *eventPane) close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	*p = eventPane{}
+}
+
+func (p *eventPane) add(event lib.Event) {
+	if p.paused {
+		p.held, _ = appendLimited(p.held, event)
+		return
+	}
+
+	var dropped int
+	p.events, dropped = appendLimited(p.events, event)
+	if dropped > 0 {
+		p.cursor = clampIndex(p.cursor-dropped, len(p.events))
+		p.offset = clampIndex(p.offset-dropped, len(p.events))
+	}
+	if p.follow {
+		p.cursor = len(p.events) - 1
+	}
+}
+
+func (p *eventPane) togglePause() {
+	p.paused = !p.paused
+	if p.paused {
+		return
+	}
+
+	held := p.held
+	p.held = nil
+	for _, event := range held {
+		p.add(event)
+	}
+}
+
+// move moves the selection by n events, following again at the last one
+func (p *eventPane) move(n int) {
+	p.cursor = clampIndex(p.cursor+n, len(p.events))
+	p.follow = p.cursor == len(p.events)-1
+}
+
+// find selects the next event matching the search in direction dir, starting
+// with the selected one if current is set
+func (p *eventPane) find(dir int, current bool) bool {
+	start := p.cursor + dir
+	if current {
+		start = p.cursor
+	}
+
+	for i := 0; i < len(p.events); i++ {
+		ix := ((start+dir*i)%len(p.events) + len(p.events)) % len(p.events)
+		event := p.events[ix]
+		if containsFold(eventLine(event), p.search) || containsFold(event.Stream, p.search) {
+			p.cursor = ix
+			p.follow = false
+			return true
+		}
+	}
+	return false
+}
+
+func (p *eventPane) scroll(height int) {
+	if p.follow {
+		p.offset = clampIndex(len(p.events)-height, len(p.events))
+	}
+	p.offset = scrollOffset(p.offset, p.cursor, height)
+}
+
+// appendLimited appends event to events and returns them with the number of
+// events dropped. Beyond uiMaxEvents, the oldest uiTrimEvents are dropped.
+func appendLimited(events []lib.Event, event lib.Event) ([]lib.Event, int) {
+	events = append(events, event)
+	if len(events) <= uiMaxEvents {
+		return events, 0
+	}
+	return append(events[:0], events[uiTrimEvents:]...), uiTrimEvents
+}
+
+// eventLine is the message of an event on a single line
+func eventLine(event lib.Event) string {
+	return strings.ReplaceAll(strings.TrimRight(event.Message(), "\n"), "\n", " ↵ ")
+}
+
+func streamColor(stream string) tcell.Color {
+	hash := 0
+	for _, r := range stream {
+		hash = hash*31 + int(r)
+	}
+	if hash < 0 {
+		hash = -hash
+	}
+	return uiStreamColors[hash%len(uiStreamColors)]
+}
+
+// scrollOffset returns the first row shown so cursor is within height rows
+func scrollOffset(offset int, cursor int, height int) int {
+	if cursor < offset {
+		return cursor
+	}
+	if cursor >= offset+height {
+		return cursor - height + 1
+	}
+	return offset
+}
+
+// clampIndex returns n limited to a position in a list of size items, or 0
+// for an empty one
+func clampIndex(n int, size int) int {
+	if n >= size {
+		n = size - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// containsFold reports whether substr is within s ignoring case
+func containsFold(s string, substr string) bool {
+	return matchFold([]rune(s), []rune(substr)) != nil
+}
+
+// matchFold marks the runes of s that are part of a case insensitive match of
+// substr, or returns nil without matches
+func matchFold(s []rune, substr []rune) []bool {
+	if len(substr) == 0 {
+		return []bool{}
+	}
+
+	var marks []bool
+	for i := 0; i+len(substr) <= len(s); {
+		match := true
+		for j, r := range substr {
+			if unicode.ToLower(s[i+j]) != unicode.ToLower(r) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			i++
+			continue
+		}
+
+		if marks == nil {
+			marks = make([]bool, len(s))
+		}
+		for j := range substr {
+			marks[i+j] = true
+		}
+		i += len(substr)
+	}
+	return marks
+}
+
+// drawText draws text on row y from column x up to column end, highlighting
+// the matches of highlight, and returns the column after it
+func drawText(screen tcell.Screen, x int, y int, end int, text string, style tcell.Style, highlight string) int {
+	runes := []rune(text)
+	marks := []bool{}
+	if highlight != "" {
+		marks = matchFold(runes, []rune(highlight))
+	}
+
+	for i, r := range runes {
+		if r == '\t' {
+			r = ' '
+		}
+		if unicode.IsControl(r) {
+			continue
+		}
+
+		w := runewidth.RuneWidth(r)
+		if x+w > end {
+			break
+		}
+
+		s := style
+		if i < len(marks) && marks[i] {
+			s = uiHighlightStyle
+		}
+		screen.SetContent(x, y, r, nil, s)
+		x += w
+	}
+	return x
+}
+
+// fill paints row y with style
+func fill(screen tcell.Screen, y int, width int, style tcell.Style) {
+	for x := 0; x < width; x++ {
+		screen.SetContent(x, y, ' ', nil, style)
+	}
+}
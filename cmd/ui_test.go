@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pecigonzalo/loro/lib"
+)
+
+func uiEvent(stream string, message string) lib.Event {
+	return lib.Event{Event: map[string]interface{}{"message": message}, Stream: stream}
+}
+
+// paneMessages returns the messages of the events of the pane
+func paneMessages(events []lib.Event) []string {
+	messages := make([]string, 0, len(events))
+	for _, event := range events {
+		messages = append(messages, event.Message())
+	}
+	return messages
+}
+
+func TestUIList(t *testing.T) {
+	list := &uiList{}
+	list.set([]string{"/ecs/api", "/ecs/worker", "/lambda/API-handler", "/rds/db"}, nil)
+
+	list.move(2)
+	if name, _ := list.selected(); name != "/lambda/API-handler" {
+		t.Errorf("got %s selected", name)
+	}
+	list.move(10)
+	if name, _ := list.selected(); name != "/rds/db" {
+		t.Errorf("got %s selected past the end", name)
+	}
+
+	// Filtering ignores case and selects the first match
+	list.setFilter("api")
+	if name, _ := list.selected(); name != "/ecs/api" || fmt.Sprint(list.visible) != "[0 2]" {
+		t.Errorf("got %s selected of %v", name, list.visible)
+	}
+	list.move(1)
+	if name, _ := list.selected(); name != "/lambda/API-handler" {
+		t.Errorf("got %s selected", name)
+	}
+	list.move(-5)
+	if name, _ := list.selected(); name != "/ecs/api" {
+		t.Errorf("got %s selected before the start", name)
+	}
+
+	// New names keep the filter
+	list.set([]string{"/ecs/api", "/ecs/api-v2", "/ecs/web"}, nil)
+	if fmt.Sprint(list.visible) != "[0 1]" {
+		t.Errorf("got %v visible", list.visible)
+	}
+
+	list.setFilter("nothing")
+	if name, ok := list.selected(); ok {
+		t.Errorf("got %s selected without matches", name)
+	}
+	list.move(1)
+	if list.cursor != 0 {
+		t.Errorf("got cursor %d without matches", list.cursor)
+	}
+}
+
+func TestAppendLimited(t *testing.T) {
+	events := []lib.Event{}
+	var dropped int
+	for i := 0; i < uiMaxEvents; i++ {
+		if events, dropped = appendLimited(events, uiEvent("a", fmt.Sprint(i))); dropped != 0 {
+			t.Fatalf("dropped %d events at %d", dropped, i)
+		}
+	}
+	events, dropped = appendLimited(events, uiEvent("a", fmt.Sprint(uiMaxEvents)))
+	if dropped != uiTrimEvents || len(events) != uiMaxEvents-uiTrimEvents+1 {
+		t.Errorf("got %d events and %d dropped", len(events), dropped)
+	}
+	if first, last := events[0].Message(), events[len(events)-1].Message(); first != fmt.Sprint(uiTrimEvents) || last != fmt.Sprint(uiMaxEvents) {
+		t.Errorf("got events from %s to %s", first, last)
+	}
+}
+
+// Trimming old events keeps the same event selected, or the oldest one left
+// when the selected event was dropped
+func TestEventPaneTrim(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor int
+		follow bool
+		want   string
+	}{
+		{name: "kept", cursor: 5000, want: "5000"},
+		{name: "dropped", cursor: 10, want: fmt.Sprint(uiTrimEvents)},
+		{name: "following", cursor: uiMaxEvents - 1, follow: true, want: fmt.Sprint(uiMaxEvents)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pane := &eventPane{}
+			for i := 0; i < uiMaxEvents; i++ {
+				pane.add(uiEvent("a", fmt.Sprint(i)))
+			}
+			pane.cursor, pane.offset, pane.follow = test.cursor, test.cursor, test.follow
+
+			pane.add(uiEvent("a", fmt.Sprint(uiMaxEvents)))
+			if got := pane.events[pane.cursor].Message(); got != test.want {
+				t.Errorf("got %s selected, want %s", got, test.want)
+			}
+			if pane.offset > pane.cursor {
+				t.Errorf("got offset %d after cursor %d", pane.offset, pane.cursor)
+			}
+		})
+	}
+}
+
+// Events received while paused are added in order once resumed
+func TestEventPanePause(t *testing.T) {
+	pane := &eventPane{follow: true}
+	pane.add(uiEvent("a", "1"))
+	pane.togglePause()
+	pane.add(uiEvent("b", "2"))
+	pane.add(uiEvent("a", "3"))
+	if got := fmt.Sprint(paneMessages(pane.events), paneMessages(pane.held)); got != "[1] [2 3]" {
+		t.Errorf("got events and held events %s while paused", got)
+	}
+
+	pane.togglePause()
+	pane.add(uiEvent("a", "4"))
+	if got := fmt.Sprint(paneMessages(pane.events), len(pane.held)); got != "[1 2 3 4] 0" {
+		t.Errorf("got events and held events %s after resuming", got)
+	}
+	if pane.cursor != 3 {
+		t.Errorf("got cursor %d, want the latest event", pane.cursor)
+	}
+}
+
+// Searching wraps around the events in both directions
+func TestEventPaneFind(t *testing.T) {
+	pane := &eventPane{}
+	for _, message := range []string{"GET /users", "error: timeout", "GET /orders", "ERROR: refused", "done"} {
+		pane.add(uiEvent("api", message))
+	}
+	pane.add(uiEvent("Worker", "started"))
+
+	tests := []struct {
+		cursor  int
+		search  string
+		dir     int
+		current bool
+		want    int
+		found   bool
+	}{
+		{cursor: 0, search: "error", dir: 1, want: 1, found: true},
+		{cursor: 1, search: "error", dir: 1, want: 3, found: true},
+		{cursor: 3, search: "error", dir: 1, want: 1, found: true},
+		{cursor: 1, search: "error", dir: -1, want: 3, found: true},
+		{cursor: 1, search: "error", dir: 1, current: true, want: 1, found: true},
+		{cursor: 2, search: "error", dir: -1, current: true, want: 1, found: true},
+		{cursor: 0, search: "worker", dir: -1, want: 5, found: true},
+		{cursor: 4, search: "missing", dir: 1, want: 4},
+	}
+	for _, test := range tests {
+		pane.cursor, pane.search, pane.follow = test.cursor, test.search, true
+		found := pane.find(test.dir, test.current)
+		if found != test.found || pane.cursor != test.want {
+			t.Errorf("find %q from %d in direction %d: got %d (%v), want %d (%v)", test.search, test.cursor, test.dir, pane.cursor, found, test.want, test.found)
+		}
+		if pane.follow == found {
+			t.Errorf("find %q from %d: got follow %v", test.search, test.cursor, pane.follow)
+		}
+	}
+
+	if (&eventPane{search: "x"}).find(1, false) {
+		t.Error("found an event without events")
+	}
+}
+
+func TestMatchFold(t *testing.T) {
+	marks := func(s string, substr string) string {
+		m := matchFold([]rune(s), []rune(substr))
+		if m == nil {
+			return "nil"
+		}
+		out := ""
+		for _, marked := range m {
+			if marked {
+				out += "^"
+			} else {
+				out += "."
+			}
+		}
+		return out
+	}
+
+	tests := []struct {
+		s, substr string
+		want      string
+	}{
+		{"Error error", "ERROR", "^^^^^.^^^^^"},
+		{"aaaa", "aa", "^^^^"},
+		{"aaa", "aa", "^^."},
+		{"ÉCOLE école", "éco", "^^^...^^^.."},
+		{"abc", "", ""},
+		{"abc", "abcd", "nil"},
+		{"abc", "x", "nil"},
+	}
+	for _, test := range tests {
+		if got := marks(test.s, test.substr); got != test.want {
+			t.Errorf("matchFold(%q, %q) = %s, want %s", test.s, test.substr, got, test.want)
+		}
+	}
+}
+
+func TestScrollOffset(t *testing.T) {
+	tests := []struct {
+		offset, cursor, height int
+		want                   int
+	}{
+		{offset: 0, cursor: 3, height: 10, want: 0},
+		{offset: 5, cursor: 2, height: 10, want: 2},
+		{offset: 0, cursor: 10, height: 10, want: 1},
+		{offset: 0, cursor: 9, height: 10, want: 0},
+		{offset: 20, cursor: 25, height: 10, want: 20},
+	}
+	for _, test := range tests {
+		if got := scrollOffset(test.offset, test.cursor, test.height); got != test.want {
+			t.Errorf("scrollOffset(%d, %d, %d) = %d, want %d", test.offset, test.cursor, test.height, got, test.want)
+		}
+	}
+}
+
+func TestClampIndex(t *testing.T) {
+	tests := []struct {
+		n, size int
+		want    int
+	}{
+		{n: 3, size: 10, want: 3},
+		{n: -1, size: 10, want: 0},
+		{n: 10, size: 10, want: 9},
+		{n: 5, size: 0, want: 0},
+		{n: -5, size: 0, want: 0},
+	}
+	for _, test := range tests {
+		if got := clampIndex(test.n, test.size); got != test.want {
+			t.Errorf("clampIndex(%d, %d) = %d, want %d", test.n, test.size, got, test.want)
+		}
+	}
+}
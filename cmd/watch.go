@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pecigonzalo/loro/lib"
+	"github.com/segmentio/events/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// watchActionTimeout is the time given to a command or webhook to
+	// complete
+	watchActionTimeout = 30 * time.Second
+	// bannerEvents is the number of triggering events printed in a banner
+	bannerEvents = 5
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch --rules <file> [group...]",
+	Short: "Follow groups and run actions when rules match",
+	Long: `Follow groups and run actions when rules match.
+
+Rules are read from a YAML file. A rule fires when threshold events matching
+its pattern (a regular expression on the message) and where expression are
+logged within its window, then waits for its cooldown before firing again.
+When no groups are given, the groups of the rules are followed.
+
+  rules:
+    - name: api-errors
+      group: /ecs/api
+      pattern: "ERROR|panic"
+      where: 'status >= 500'
+      threshold: 5        # default 1
+      window: 1m          # default 1m
+      cooldown: 10m       # default is the window
+      actions:            # default is a banner
+        - banner: true
+        - command: notify-send "loro" "$LORO_RULE fired"
+        - webhook: https://hooks.example.com/alerts
+
+Commands run with sh -c and webhooks are POSTed to, both getting the alert as
+JSON with the number of matches in the window and the latest 100 of them in
+the --output jsonl format. Commands also get it in the LORO_RULE, LORO_COUNT
+and LORO_TIME environment variables. An action is skipped while its previous
+run has not finished.`,
+	RunE: watch,
+}
+
+var (
+	watchRules string
+	watchSince string
+)
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringVar(&watchRules, "rules", "", "YAML file with the rules")
+	watchCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "Stream Name or prefix")
+	watchCmd.Flags().StringVarP(&watchSince, "since", "s", "1m", "Evaluate rules from timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	watchCmd.Flags().StringVar(&filterPattern, "filter", "", "CloudWatch filter pattern applied server side (e.g. ERROR or '{ $.level = \"error\" }')")
+	watchCmd.Flags().StringVar(&parserName, "parser", lib.DefaultParser, "Message parser, regex:<expression> with named groups or one of: "+strings.Join(lib.Parsers(), ", "))
+}
+
+func watch(cmd *cobra.Command, args []string) error {
+	if watchRules == "" {
+		return fmt.Errorf("--rules is required")
+	}
+
+	rules, err := lib.LoadWatchRules(watchRules)
+	if err != nil {
+		return err
+	}
+
+	groups := args
+	if len(groups) == 0 {
+		seen := map[string]bool{}
+		for _, rule := range rules {
+			if rule.Group == "" {
+				return fmt.Errorf("rule '%s' has no group, give the groups to follow as arguments", rule.Name)
+			}
+			if !seen[rule.Group] {
+				seen[rule.Group] = true
+				groups = append(groups, rule.Group)
+			}
+		}
+	}
+
+	start, err := lib.GetTime(watchSince, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", watchSince)
+	}
+
+	parser, err := lib.NewParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lib.SetMaxStreams(100)
+
+	logReaders, eventChans, err := readGroups(ctx, groups, start, time.Time{}, true, parser, nil)
+	if err != nil {
+		return err
+	}
+	notify(fmt.Sprintf("watching %d groups with %d rules", len(logReaders), len(rules)))
+
+	// Actions run in the background so slow ones do not hold back events
+	actions := newActionRunner()
+	defer actions.wait()
+
+	watcher := lib.NewWatcher(rules)
+	for event := range lib.MergeEvents(ctx, lib.DefaultMergeBufferSize, lib.DefaultMergeDelay, eventChans...) {
+		for _, alert := range watcher.Observe(event) {
+			payload, err := newAlertPayload(alert)
+			if err != nil {
+				return err
+			}
+
+			for i, action := range alert.Rule.Actions {
+				if action.Banner {
+					printBanner(os.Stdout, alert)
+					continue
+				}
+
+				action := action
+				started := actions.start(actionKey{rule: payload.Rule, action: i}, func() {
+					if err := runAction(action, payload); err != nil {
+						notify(fmt.Sprintf("rule '%s': %s", payload.Rule, err))
+					}
+				})
+				if !started {
+					notify(fmt.Sprintf("rule '%s': skipped action %d, its previous run has not finished", payload.Rule, i+1))
+				}
+			}
+		}
+	}
+
+	return readersError(ctx, logReaders)
+}
+
+// actionKey identifies an action of a rule
+type actionKey struct {
+	rule   string
+	action int
+}
+
+// actionRunner runs actions in the background, at most one run of each
+// action at a time
+type actionRunner struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[actionKey]bool
+}
+
+func newActionRunner() *actionRunner {
+	return &actionRunner{running: map[actionKey]bool{}}
+}
+
+// start runs an action in the background, or returns false if its previous
+// run is still in flight
+func (r *actionRunner) start(key actionKey, run func()) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[key] {
+		return false
+	}
+	r.running[key] = true
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		run()
+
+		r.mu.Lock()
+		delete(r.running, key)
+		r.mu.Unlock()
+	}()
+	return true
+}
+
+// wait waits for the running actions to finish
+func (r *actionRunner) wait() {
+	r.wg.Wait()
+}
+
+// alertPayload is the JSON representation of an alert given to commands and
+// webhooks
+type alertPayload struct {
+	Rule      string            `json:"rule"`
+	Time      time.Time         `json:"time"`
+	Count     int               `json:"count"`
+	Threshold int               `json:"threshold"`
+	Window    string            `json:"window"`
+	Events    []json.RawMessage `json:"events"`
+}
+
+func newAlertPayload(alert lib.Alert) (*alertPayload, error) {
+	payload := &alertPayload{
+		Rule:      alert.Rule.Name,
+		Time:      alert.Time,
+		Count:     alert.Count,
+		Threshold: alert.Rule.Threshold,
+		Window:    alert.Rule.Window.String(),
+		Events:    make([]json.RawMessage, 0, len(alert.Events)),
+	}
+	for _, event := range alert.Events {
+		data, err := encodeEvent(event)
+		if err != nil {
+			return nil, err
+		}
+		payload.Events = append(payload.Events, data)
+	}
+	return payload, nil
+}
+
+// runAction runs the command of an action or POSTs to its webhook. Actions
+// are not interrupted with the command, they complete up to their timeout.
+func runAction(action lib.WatchAction, payload *alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), watchActionTimeout)
+	defer cancel()
+
+	if action.Command != "" {
+		command := exec.CommandContext(ctx, "sh", "-c", action.Command)
+		command.Stdin = bytes.NewReader(body)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+		command.Env = append(os.Environ(),
+			"LORO_RULE="+payload.Rule,
+			"LORO_COUNT="+strconv.Itoa(payload.Count),
+			"LORO_TIME="+payload.Time.Format(time.RFC3339Nano),
+		)
+		if err := command.Run(); err != nil {
+			return fmt.Errorf("command failed: %w", err)
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, action.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// printBanner writes a highlighted alert with its latest events
+func printBanner(out io.Writer, alert lib.Alert) {
+	rule := alert.Rule
+	title := fmt.Sprintf("▌ %s fired at %s: %d events in %s", rule.Name, alert.Time.Local().Format(lib.ShortTimeFormat), alert.Count, rule.Window)
+	fmt.Fprintln(out, lib.Red(title))
+
+	shown := alert.Events
+	if len(shown) > bannerEvents {
+		shown = shown[len(shown)-bannerEvents:]
+	}
+	if alert.Count > len(shown) {
+		fmt.Fprintln(out, lib.Red("▌"), fmt.Sprintf("... %d more", alert.Count-len(shown)))
+	}
+	for _, event := range shown {
+		message, _, _ := strings.Cut(event.Message(), "\n")
+		fmt.Fprintln(out, lib.Red("▌"), fmt.Sprintf("[ %s ] %s - %s", lib.Unique(event.Stream), event.TimeShort(), message))
+	}
+}
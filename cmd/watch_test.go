@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// An action is skipped while its previous run is in flight, without holding
+// back other actions
+func TestActionRunner(t *testing.T) {
+	runner := newActionRunner()
+	release := make(chan struct{})
+	var runs int32
+	run := func() {
+		<-release
+		atomic.AddInt32(&runs, 1)
+	}
+
+	slow := actionKey{rule: "errors", action: 0}
+	if !runner.start(slow, run) {
+		t.Fatal("first run skipped")
+	}
+	if runner.start(slow, run) {
+		t.Error("started while the previous run is in flight")
+	}
+	if !runner.start(actionKey{rule: "errors", action: 1}, run) || !runner.start(actionKey{rule: "panics", action: 0}, run) {
+		t.Error("other actions skipped")
+	}
+
+	close(release)
+	runner.wait()
+	if runs != 3 {
+		t.Errorf("got %d runs, want 3", runs)
+	}
+	if !runner.start(slow, run) {
+		t.Error("skipped after the previous run finished")
+	}
+	runner.wait()
+}
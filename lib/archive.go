@@ -0,0 +1,653 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	// coverageName is the name of the file listing the windows of a group
+	// the archive fully holds
+	coverageName = "coverage.json"
+	// archiveHourFormat names the archive files, one per stream and hour
+	archiveHourFormat = "2006-01-02T15"
+	// maxArchiveFiles is the number of archive files kept open for writing
+	maxArchiveFiles = 64
+	// maxArchiveIDSets is the number of archive files whose event IDs are
+	// kept in memory, so files closed to open others are not read again
+	maxArchiveIDSets = 4 * maxArchiveFiles
+)
+
+// Archive is a local store of log events, kept as one JSON Lines file per
+// group, stream and hour. Along with the events it records which windows of
+// each group were read completely, so reads of those windows can be answered
+// without calling CloudWatch. It is safe for concurrent use.
+type Archive struct {
+	dir   string
+	mu    sync.Mutex
+	files map[string]*archiveFile
+	ids   *lru.Cache[string, map[string]bool]
+	uses  uint64
+}
+
+// ArchiveCoverage is a window of a group, limited to the streams starting
+// with Prefix, whose events are all held in the archive
+type ArchiveCoverage struct {
+	Prefix string    `json:"prefix,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// archiveRecord is how an event is stored in the archive
+type archiveRecord struct {
+	ID            string `json:"id"`
+	Timestamp     int64  `json:"timestamp"`
+	IngestionTime int64  `json:"ingestion_time"`
+	Message       string `json:"message"`
+}
+
+type archiveFile struct {
+	out    *os.File
+	buffer *bufio.Writer
+	// used orders the open files by last write, the least recently written
+	// is closed first
+	used uint64
+}
+
+// archiveSegment is a part of a window either held in the archive or not
+type archiveSegment struct {
+	start int64
+	end   int64
+	local bool
+}
+
+// ArchiveDir returns the default directory of the archive
+func ArchiveDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "loro", "archive"), nil
+}
+
+// OpenArchive opens the archive in dir, creating it if needed
+func OpenArchive(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ids, err := lru.New[string, map[string]bool](maxArchiveIDSets)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{dir: dir, files: map[string]*archiveFile{}, ids: ids}, nil
+}
+
+// archiveName escapes a group or stream name so it can be used as a single
+// path element
+func archiveName(name string) string {
+	escaped := url.PathEscape(name)
+	if escaped == "." || escaped == ".." {
+		return strings.ReplaceAll(escaped, ".", "%2E")
+	}
+	return escaped
+}
+
+func (a *Archive) groupDir(group string) string {
+	return filepath.Join(a.dir, archiveName(group))
+}
+
+// Write stores an event of group unless the archive already holds it
+func (a *Archive) Write(group string, event types.FilteredLogEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hour := time.UnixMilli(aws.ToInt64(event.Timestamp)).UTC().Format(archiveHourFormat)
+	path := filepath.Join(a.groupDir(group), archiveName(aws.ToString(event.LogStreamName)), hour+".jsonl")
+
+	file, ok := a.files[path]
+	if !ok {
+		if len(a.files) >= maxArchiveFiles {
+			if err := a.closeOldest(); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		file, err = openArchiveFile(path)
+		if err != nil {
+			return err
+		}
+		a.files[path] = file
+	}
+	a.uses++
+	file.used = a.uses
+
+	ids, err := a.fileIDs(path, file)
+	if err != nil {
+		return err
+	}
+	id := aws.ToString(event.EventId)
+	if ids[id] {
+		return nil
+	}
+
+	content, err := json.Marshal(archiveRecord{
+		ID:            id,
+		Timestamp:     aws.ToInt64(event.Timestamp),
+		IngestionTime: aws.ToInt64(event.IngestionTime),
+		Message:       aws.ToString(event.Message),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := file.buffer.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	ids[id] = true
+
+	return nil
+}
+
+// fileIDs returns the IDs of the events held in the archive file at path,
+// reading them from the file unless they are still in memory
+func (a *Archive) fileIDs(path string, file *archiveFile) (map[string]bool, error) {
+	if ids, ok := a.ids.Get(path); ok {
+		return ids, nil
+	}
+
+	// Buffered events are not in the file yet
+	if err := file.buffer.Flush(); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		var record archiveRecord
+		if json.Unmarshal(line, &record) == nil {
+			ids[record.ID] = true
+		}
+	}
+
+	a.ids.Add(path, ids)
+	return ids, nil
+}
+
+// openArchiveFile opens an archive file for appending
+func openArchiveFile(path string) (*archiveFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	file := &archiveFile{out: out, buffer: bufio.NewWriter(out)}
+	// Terminate a line left incomplete by an interrupted write
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := out.ReadAt(last, size-1); err != nil {
+			out.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			file.buffer.WriteByte('\n')
+		}
+	}
+
+	return file, nil
+}
+
+// flush writes the buffered events of every open file
+func (a *Archive) flush() error {
+	for _, file := range a.files {
+		if err := file.buffer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeOldest closes the open file written the longest time ago
+func (a *Archive) closeOldest() error {
+	var oldest string
+	for path, file := range a.files {
+		if oldest == "" || file.used < a.files[oldest].used {
+			oldest = path
+		}
+	}
+
+	file := a.files[oldest]
+	delete(a.files, oldest)
+	if err := file.buffer.Flush(); err != nil {
+		file.out.Close()
+		return err
+	}
+	return file.out.Close()
+}
+
+func (a *Archive) closeFiles() error {
+	var firstErr error
+	for path, file := range a.files {
+		if err := file.buffer.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.out.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(a.files, path)
+	}
+	return firstErr
+}
+
+// Close writes any buffered event and closes the archive files
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeFiles()
+}
+
+// Groups returns the names of the groups held in the archive
+func (a *Archive) Groups() ([]string, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// Coverage returns the windows of group the archive fully holds
+func (a *Archive) Coverage(group string) ([]ArchiveCoverage, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.readCoverage(group)
+}
+
+func (a *Archive) readCoverage(group string) ([]ArchiveCoverage, error) {
+	path := filepath.Join(a.groupDir(group), coverageName)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []ArchiveCoverage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := []ArchiveCoverage{}
+	if err := json.Unmarshal(content, &coverage); err != nil {
+		return nil, fmt.Errorf("failed to read archive coverage '%s': %w", path, err)
+	}
+	return coverage, nil
+}
+
+// Cover records that every event of group in [start, end], for the streams
+// starting with prefix, is held in the archive
+func (a *Archive) Cover(group string, prefix string, start time.Time, end time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Events must be on disk before the window is claimed
+	if err := a.flush(); err != nil {
+		return err
+	}
+
+	coverage, err := a.readCoverage(group)
+	if err != nil {
+		return err
+	}
+	coverage = append(coverage, ArchiveCoverage{
+		Prefix: prefix,
+		Start:  time.UnixMilli(start.UnixMilli()).UTC(),
+		End:    time.UnixMilli(end.UnixMilli()).UTC(),
+	})
+
+	sort.Slice(coverage, func(i, j int) bool {
+		if coverage[i].Prefix != coverage[j].Prefix {
+			return coverage[i].Prefix < coverage[j].Prefix
+		}
+		return coverage[i].Start.Before(coverage[j].Start)
+	})
+	merged := []ArchiveCoverage{}
+	for _, window := range coverage {
+		if n := len(merged); n > 0 && merged[n-1].Prefix == window.Prefix &&
+			window.Start.UnixMilli() <= merged[n-1].End.UnixMilli()+1 {
+			if window.End.After(merged[n-1].End) {
+				merged[n-1].End = window.End
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+
+	content, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.groupDir(group), coverageName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// segments splits the [start, end] window of group into the parts held in
+// the archive and the gaps that need to be fetched
+func (a *Archive) segments(group string, prefix string, start int64, end int64) ([]archiveSegment, error) {
+	coverage, err := a.Coverage(group)
+	if err != nil {
+		return nil, err
+	}
+
+	// Windows read for a shorter prefix hold every stream of a longer one
+	windows := []archiveSegment{}
+	for _, window := range coverage {
+		if strings.HasPrefix(prefix, window.Prefix) {
+			windows = append(windows, archiveSegment{start: window.Start.UnixMilli(), end: window.End.UnixMilli(), local: true})
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+
+	segments := []archiveSegment{}
+	next := start
+	for _, window := range windows {
+		if next > end {
+			break
+		}
+		if window.end < next || window.start > end {
+			continue
+		}
+		if window.start > next {
+			segments = append(segments, archiveSegment{start: next, end: window.start - 1})
+			next = window.start
+		}
+		stop := window.end
+		if stop > end {
+			stop = end
+		}
+		segments = append(segments, archiveSegment{start: next, end: stop, local: true})
+		next = stop + 1
+	}
+	if next <= end {
+		segments = append(segments, archiveSegment{start: next, end: end})
+	}
+
+	return segments, nil
+}
+
+// scan calls fn, in timestamp order, with the archived events of group in
+// [start, end] from the streams starting with prefix whose message matches.
+// It stops early if fn returns false.
+func (a *Archive) scan(group string, prefix string, start int64, end int64, match func(string) bool, fn func(types.FilteredLogEvent) bool) error {
+	a.mu.Lock()
+	err := a.flush()
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(a.groupDir(group))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	first := time.UnixMilli(start).UTC().Truncate(time.Hour).Format(archiveHourFormat)
+	last := time.UnixMilli(end).UTC().Format(archiveHourFormat)
+
+	// Find the hours with files in the window, per stream
+	hours := map[string][]string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		stream, err := url.PathUnescape(entry.Name())
+		if err != nil || !strings.HasPrefix(stream, prefix) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(a.groupDir(group), entry.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			hour := strings.TrimSuffix(file.Name(), ".jsonl")
+			if hour == file.Name() || hour < first || hour > last {
+				continue
+			}
+			hours[hour] = append(hours[hour], stream)
+		}
+	}
+
+	sorted := make([]string, 0, len(hours))
+	for hour := range hours {
+		sorted = append(sorted, hour)
+	}
+	sort.Strings(sorted)
+
+	for _, hour := range sorted {
+		events := []types.FilteredLogEvent{}
+		for _, stream := range hours[hour] {
+			path := filepath.Join(a.groupDir(group), archiveName(stream), hour+".jsonl")
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			for _, line := range bytes.Split(content, []byte("\n")) {
+				var record archiveRecord
+				if json.Unmarshal(line, &record) != nil {
+					continue
+				}
+				if record.Timestamp < start || record.Timestamp > end || !match(record.Message) {
+					continue
+				}
+				events = append(events, types.FilteredLogEvent{
+					EventId:       aws.String(record.ID),
+					Timestamp:     aws.Int64(record.Timestamp),
+					IngestionTime: aws.Int64(record.IngestionTime),
+					Message:       aws.String(record.Message),
+					LogStreamName: aws.String(stream),
+				})
+			}
+		}
+
+		sort.SliceStable(events, func(i, j int) bool {
+			if *events[i].Timestamp != *events[j].Timestamp {
+				return *events[i].Timestamp < *events[j].Timestamp
+			}
+			if *events[i].LogStreamName != *events[j].LogStreamName {
+				return *events[i].LogStreamName < *events[j].LogStreamName
+			}
+			return *events[i].EventId < *events[j].EventId
+		})
+		for _, event := range events {
+			if !fn(event) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetArchive stores every event the reader sends in archive. With local set,
+// the parts of the window the archive fully holds are read from it and only
+// the gaps are fetched from CloudWatch.
+func (c *CloudwatchLogsReader) SetArchive(archive *Archive, local bool) {
+	c.archive = archive
+	c.local = local
+}
+
+// store writes an event to the archive, giving up on archiving on failure.
+// The archive is still read from, only writes and coverage stop.
+func (c *CloudwatchLogsReader) store(event types.FilteredLogEvent) {
+	if err := c.archive.Write(c.logGroupName, event); err != nil {
+		c.notify(fmt.Sprintf("failed to archive events, no longer archiving: %s", err))
+		c.archiveFailed = true
+	}
+}
+
+// recordCoverage marks the window read with params as held in the archive.
+// Filtered reads never count, and coverage stops CheckpointOverlap before the
+// time of the read, or of the last poll of a follow session, to leave room
+// for late ingested events.
+func (c *CloudwatchLogsReader) recordCoverage(params *cloudwatchlogs.FilterLogEventsInput, err error, follow bool) {
+	if c.archive == nil || c.archiveFailed || c.filterPattern != "" {
+		return
+	}
+	// Prefix reads are capped to MaxStreams streams, which may leave some out
+	if c.streamPrefix != "" && len(params.LogStreamNames) >= MaxStreams {
+		return
+	}
+
+	if (err != nil && !follow) || c.polled.IsZero() {
+		return
+	}
+
+	start := aws.ToInt64(params.StartTime)
+	end := aws.ToInt64(params.EndTime)
+	if limit := c.polled.Add(-CheckpointOverlap).UnixMilli(); follow || end > limit {
+		end = limit
+	}
+	if end < start {
+		return
+	}
+
+	if err := c.archive.Cover(c.logGroupName, c.streamPrefix, time.UnixMilli(start), time.UnixMilli(end)); err != nil {
+		c.notify(fmt.Sprintf("failed to record archive coverage: %s", err))
+	}
+}
+
+// localEvents sends the parts of the window held in the archive from it and
+// fetches the rest. When following, polling continues after the window.
+func (c *CloudwatchLogsReader) localEvents(ctx context.Context, eventChan chan<- Event, follow bool) error {
+	match, err := localFilter(c.filterPattern)
+	if err != nil {
+		c.notify(fmt.Sprintf("filter pattern '%s' can not be evaluated locally, reading from CloudWatch", c.filterPattern))
+		params, err := c.filterParams(ctx, follow)
+		if err != nil {
+			return err
+		}
+		return c.fetchEvents(ctx, eventChan, params, follow)
+	}
+
+	end := c.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	segments, err := c.archive.segments(c.logGroupName, c.streamPrefix, c.start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		return err
+	}
+	// Following continues from the end of the window
+	if follow {
+		if n := len(segments); n > 0 && !segments[n-1].local {
+			segments[n-1].end = 0
+		} else {
+			segments = append(segments, archiveSegment{start: end.UnixMilli() + 1})
+		}
+	}
+
+	var base *cloudwatchlogs.FilterLogEventsInput
+	for i, segment := range segments {
+		if segment.local {
+			err := c.archive.scan(c.logGroupName, c.streamPrefix, segment.start, segment.end, match, func(event types.FilteredLogEvent) bool {
+				return c.send(ctx, eventChan, event)
+			})
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		tail := follow && i == len(segments)-1
+		if base == nil {
+			base, err = c.filterParams(ctx, follow)
+			var noStreams *NoLogStreamsError
+			if errors.As(err, &noStreams) && len(segments) > 1 {
+				if !tail {
+					// Nothing to fetch for this gap, the archive may hold the rest
+					continue
+				}
+				base, err = c.waitForStreams(ctx)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		params := *base
+		params.NextToken = nil
+		params.StartTime = aws.Int64(segment.start)
+		params.EndTime = aws.Int64(segment.end)
+		if tail {
+			params.EndTime = nil
+		}
+		c.polled = time.Time{}
+		if err := c.fetchEvents(ctx, eventChan, &params, tail); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForStreams builds the params of a follow session once streams matching
+// the reader appear, checking again every MaxPollInterval
+func (c *CloudwatchLogsReader) waitForStreams(ctx context.Context) (*cloudwatchlogs.FilterLogEventsInput, error) {
+	for {
+		if err := sleep(ctx, MaxPollInterval); err != nil {
+			return nil, err
+		}
+		params, err := c.filterParams(ctx, true)
+		var noStreams *NoLogStreamsError
+		if !errors.As(err, &noStreams) {
+			return params, err
+		}
+	}
+}
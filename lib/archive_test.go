@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// newTestArchive returns an archive holding an event at each of the given
+// seconds after testStart in stream, and covering the given windows
+func newTestArchive(t *testing.T, prefix string, stream string, seconds []int, windows ...[2]int) *Archive {
+	t.Helper()
+	archive, err := OpenArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { archive.Close() })
+
+	for _, n := range seconds {
+		err := archive.Write("group", types.FilteredLogEvent{
+			EventId:       aws.String(fmt.Sprintf("archived-%d", n)),
+			Timestamp:     aws.Int64(testTime(n)),
+			IngestionTime: aws.Int64(testTime(n)),
+			Message:       aws.String(fmt.Sprint(n)),
+			LogStreamName: aws.String(stream),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, window := range windows {
+		if err := archive.Cover("group", prefix, time.UnixMilli(testTime(window[0])), time.UnixMilli(testTime(window[1]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archive
+}
+
+func newLocalReader(t *testing.T, svc LogSource, archive *Archive, prefix string, end time.Time) *CloudwatchLogsReader {
+	t.Helper()
+	reader, err := NewCloudwatchLogsReaderWithSource(svc, "group", prefix, testStart, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.SetArchive(archive, true)
+	return reader
+}
+
+// A failure to archive the events of a gap stops archiving, but the segments
+// after it are still read from the archive
+func TestLocalEventsArchiveFailure(t *testing.T) {
+	archive := newTestArchive(t, "", "a", []int{10, 250}, [2]int{0, 99}, [2]int{200, 299})
+
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "b", testTime(150), "150")
+
+	// Writing the event of the gap fails as its stream directory is a file
+	if err := os.WriteFile(filepath.Join(archive.groupDir("group"), "b"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := newLocalReader(t, svc, archive, "", time.UnixMilli(testTime(299)))
+	equalMessages(t, readMessages(t, reader), "10", "150", "250")
+
+	coverage, err := archive.Coverage("group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 2 {
+		t.Errorf("gap marked as covered after a failed write: %v", coverage)
+	}
+}
+
+// Gaps without streams are skipped rather than ending the read
+func TestLocalEventsNoStreams(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "worker/1", testTime(150), "other stream")
+
+	archive := newTestArchive(t, "api/", "api/1", []int{10, 250}, [2]int{0, 99}, [2]int{200, 299})
+	reader := newLocalReader(t, svc, archive, "api/", time.UnixMilli(testTime(299)))
+	equalMessages(t, readMessages(t, reader), "10", "250")
+
+	t.Run("follow", func(t *testing.T) {
+		fastPolling(t)
+
+		archive := newTestArchive(t, "api/", "api/1", []int{10}, [2]int{0, 99})
+		ctx, cancel := context.WithCancel(context.Background())
+		reader := newLocalReader(t, svc, archive, "api/", time.Time{})
+		events := reader.StreamEvents(ctx, true)
+		equalMessages(t, receive(t, events, 1), "10")
+
+		// The tail is polled once a matching stream appears
+		svc.AddEvent("group", "api/1", time.Now().UnixMilli(), "new")
+		equalMessages(t, receive(t, events, 1), "new")
+
+		cancel()
+		for range events {
+		}
+	})
+}
+
+// One-shot reads leave the latest CheckpointOverlap out of the coverage, as
+// events may still be ingested for it
+func TestRecordCoverageOverlap(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(10), "10")
+
+	archive := newTestArchive(t, "", "", nil)
+	reader, err := NewCloudwatchLogsReaderWithSource(svc, "group", "", testStart, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.SetArchive(archive, false)
+	equalMessages(t, readMessages(t, reader), "10")
+	finished := time.Now()
+
+	coverage, err := archive.Coverage("group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 {
+		t.Fatalf("got coverage %v, want a single window", coverage)
+	}
+	if limit := finished.Add(-CheckpointOverlap); coverage[0].End.UnixMilli() > limit.UnixMilli() {
+		t.Errorf("coverage ends at %s, after %s", coverage[0].End, limit)
+	}
+}
+
+// Only the least recently written file is closed when too many are open, and
+// the events of closed files are still written once
+func TestArchiveOpenFiles(t *testing.T) {
+	archive, err := OpenArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(stream string, id string) {
+		t.Helper()
+		err := archive.Write("group", types.FilteredLogEvent{
+			EventId:       aws.String(id),
+			Timestamp:     aws.Int64(testTime(0)),
+			IngestionTime: aws.Int64(testTime(0)),
+			Message:       aws.String(id),
+			LogStreamName: aws.String(stream),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hour := time.UnixMilli(testTime(0)).UTC().Format(archiveHourFormat)
+	path := func(stream string) string {
+		return filepath.Join(archive.groupDir("group"), stream, hour+".jsonl")
+	}
+
+	for i := 0; i < maxArchiveFiles; i++ {
+		write(fmt.Sprintf("s%d", i), fmt.Sprintf("event-%d", i))
+	}
+	write("s0", "event-0b")
+	write("new", "event-new")
+	if len(archive.files) != maxArchiveFiles {
+		t.Errorf("got %d open files, want %d", len(archive.files), maxArchiveFiles)
+	}
+	if _, ok := archive.files[path("s1")]; ok {
+		t.Error("least recently written file is still open")
+	}
+	if _, ok := archive.files[path("s0")]; !ok {
+		t.Error("recently written file was closed")
+	}
+
+	// Reopened files know their events without reading them again
+	if !archive.ids.Contains(path("s1")) {
+		t.Error("event IDs of the closed file were dropped")
+	}
+	write("s1", "event-1")
+	// Files whose IDs were dropped from memory read them back, including
+	// buffered events
+	archive.ids.Purge()
+	write("s0", "event-0b")
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for stream, want := range map[string]int{"s0": 2, "s1": 1, "new": 1} {
+		content, err := os.ReadFile(path(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(string(content), "\n"); lines != want {
+			t.Errorf("%s: got %d events, want %d", stream, lines, want)
+		}
+	}
+}
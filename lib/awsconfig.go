@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// AWSOptions selects the credentials, region and endpoint used to reach AWS.
+// Empty fields keep the default AWS SDK behaviour (environment, shared config
+// files, instance roles...).
+type AWSOptions struct {
+	// Profile is a profile of the shared config and credentials files
+	Profile string
+	// Region overrides the region of the profile or environment
+	Region string
+	// EndpointURL sends CloudWatch Logs calls to another endpoint, e.g.
+	// http://localhost:4566 for LocalStack
+	EndpointURL string
+	// RoleARN is a role assumed with STS on top of the base credentials
+	RoleARN string
+	// ExternalID is passed along when assuming RoleARN
+	ExternalID string
+	// MFASerial is the serial number or ARN of the MFA device required to
+	// assume RoleARN. The code is read with MFATokenFunc.
+	MFASerial string
+	// MFATokenFunc returns the current MFA code
+	MFATokenFunc func() (string, error)
+}
+
+var awsOptions AWSOptions
+
+// SetAWSOptions sets the options used by every client built afterwards
+func SetAWSOptions(opts AWSOptions) {
+	awsOptions = opts
+}
+
+// LoadAWSConfig loads the AWS configuration selected by opts
+func LoadAWSConfig(ctx context.Context, opts AWSOptions) (aws.Config, error) {
+	loadOptions := []func(*config.LoadOptions) error{}
+	if opts.Profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(opts.Profile))
+	}
+	if opts.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(opts.Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if opts.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "loro"
+			if opts.ExternalID != "" {
+				o.ExternalID = aws.String(opts.ExternalID)
+			}
+			if opts.MFASerial != "" {
+				o.SerialNumber = aws.String(opts.MFASerial)
+				o.TokenProvider = opts.MFATokenFunc
+				if o.TokenProvider == nil {
+					o.TokenProvider = stscreds.StdinTokenProvider
+				}
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return cfg, nil
+}
+
+// ARNAccount returns the account ID of an ARN, or an empty string if arn is
+// not one
+func ARNAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const (
+	// DefaultBackfillWorkers is the default number of shards fetched at once
+	DefaultBackfillWorkers = 4
+	// shardBufferSize is the number of events a shard can fetch ahead of the
+	// shard currently being emitted
+	shardBufferSize = MaxEventsPerCall
+	// progressInterval is how often backfill progress is reported
+	progressInterval = 1 * time.Second
+)
+
+// BackfillProgress reports how far a sharded backfill got
+type BackfillProgress struct {
+	Group      string
+	Shards     int
+	ShardsDone int
+	Events     int
+	Elapsed    time.Duration
+}
+
+// Rate returns the number of events fetched per second
+func (p BackfillProgress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Events) / p.Elapsed.Seconds()
+}
+
+// SetShards splits one-shot fetches into shards time slices fetched by up to
+// workers concurrent calls. Events are still emitted in order. A value of 1
+// or lower fetches the whole window with a single paginator.
+func (c *CloudwatchLogsReader) SetShards(shards int, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	c.shards = shards
+	c.workers = workers
+}
+
+// SetProgressFunc sets a function that receives progress updates while a
+// sharded backfill runs
+func (c *CloudwatchLogsReader) SetProgressFunc(progress func(BackfillProgress)) {
+	c.progress = progress
+}
+
+type backfillShard struct {
+	params *cloudwatchlogs.FilterLogEventsInput
+	// started is closed once a worker picked the shard and made its events
+	// buffer, so only the shards being fetched or emitted hold one
+	started chan struct{}
+	events  chan types.FilteredLogEvent
+	err     error
+}
+
+// shardEvents fetches the [StartTime, EndTime] window of params in shards,
+// running up to c.workers fetches at once, and emits the shards in order
+func (c *CloudwatchLogsReader) shardEvents(ctx context.Context, eventChan chan<- Event, params *cloudwatchlogs.FilterLogEventsInput) error {
+	start := aws.ToInt64(params.StartTime)
+	end := aws.ToInt64(params.EndTime)
+	span := (end - start) / int64(c.shards)
+	if span < 1 {
+		return c.pollEvents(ctx, eventChan, params, false)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	shards := make([]*backfillShard, c.shards)
+	jobs := make(chan *backfillShard, c.shards)
+	for i := range shards {
+		shardParams := *params
+		shardParams.NextToken = nil
+		shardParams.StartTime = aws.Int64(start + int64(i)*span)
+		if i < len(shards)-1 {
+			// EndTime is inclusive, stop right before the next shard
+			shardParams.EndTime = aws.Int64(start + int64(i+1)*span - 1)
+		}
+		shards[i] = &backfillShard{
+			params:  &shardParams,
+			started: make(chan struct{}),
+		}
+		jobs <- shards[i]
+	}
+	close(jobs)
+
+	var (
+		mu       sync.Mutex
+		fetched  int
+		done     int
+		started  = time.Now()
+		progress = func() {
+			if c.progress == nil {
+				return
+			}
+			mu.Lock()
+			p := BackfillProgress{
+				Group:      c.logGroupName,
+				Shards:     len(shards),
+				ShardsDone: done,
+				Events:     fetched,
+				Elapsed:    time.Since(started),
+			}
+			mu.Unlock()
+			c.progress(p)
+		}
+	)
+
+	// Workers pick shards in order, so the shard being emitted is always
+	// either done or being fetched
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range jobs {
+				shard.events = make(chan types.FilteredLogEvent, shardBufferSize)
+				close(shard.started)
+				shard.err = c.fetchShard(ctx, shard, func() {
+					mu.Lock()
+					fetched++
+					mu.Unlock()
+				})
+				mu.Lock()
+				done++
+				mu.Unlock()
+				close(shard.events)
+			}
+		}()
+	}
+	defer func() {
+		// Stop any worker still fetching before waiting for them
+		cancel()
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for _, shard := range shards {
+		select {
+		case <-shard.started:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	ShardLoop:
+		for {
+			select {
+			case event, ok := <-shard.events:
+				if !ok {
+					break ShardLoop
+				}
+				if !c.emit(ctx, eventChan, event) {
+					return ctx.Err()
+				}
+			case <-ticker.C:
+				progress()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if shard.err != nil {
+			return shard.err
+		}
+	}
+	progress()
+
+	return nil
+}
+
+// fetchShard pages through a shard, calling fetched for each event
+func (c *CloudwatchLogsReader) fetchShard(ctx context.Context, shard *backfillShard, fetched func()) error {
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(c.svc, shard.params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, event := range page.Events {
+			select {
+			case shard.events <- event:
+				fetched()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
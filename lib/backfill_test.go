@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Sharded fetches send the same events, in the same order, as a single one
+func TestShardEvents(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.PageSize = 3
+	for i := 0; i < 60; i++ {
+		svc.AddEvent("group", fmt.Sprintf("api/%d", i%3), testTime(i*10), fmt.Sprintf("event %d", i))
+	}
+	// Events on the boundaries of the shards and sharing a timestamp
+	for _, n := range []int{100, 200, 300, 599, 600} {
+		svc.AddEvent("group", "api/0", testTime(n), fmt.Sprintf("boundary %d", n))
+		svc.AddEvent("group", "api/1", testTime(n), fmt.Sprintf("boundary %d", n))
+	}
+
+	end := testStart.Add(10 * time.Minute)
+	read := func(shards int, workers int) []string {
+		reader, err := NewCloudwatchLogsReaderWithSource(svc, "group", "", testStart, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.SetShards(shards, workers)
+		return readMessages(t, reader)
+	}
+
+	want := read(1, 1)
+	if len(want) != 70 {
+		t.Fatalf("got %d events unsharded, want 70", len(want))
+	}
+	for _, test := range []struct{ shards, workers int }{{6, 1}, {6, 2}, {6, 6}, {7, 3}, {50, 4}, {700000, 4}} {
+		t.Run(fmt.Sprintf("%d shards %d workers", test.shards, test.workers), func(t *testing.T) {
+			equalMessages(t, read(test.shards, test.workers), want...)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	// CheckpointOverlap is how far before the checkpoint timestamp a resumed
+	// session starts reading, to pick up events ingested late. Events in that
+	// window that were already seen are skipped using the stored event IDs.
+	CheckpointOverlap = 1 * time.Minute
+	// MaxCheckpointEvents is the number of event IDs a checkpoint holds before
+	// the ones outside the overlap window, then the oldest ones, are pruned
+	MaxCheckpointEvents = 10000
+)
+
+var checkpointNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Checkpoint records how far a follow session got, so it can be resumed
+// without duplicating or skipping events. It stores the timestamp of the
+// newest event seen plus the IDs of the events seen within
+// CheckpointOverlap of it.
+type Checkpoint struct {
+	Name      string           `json:"name"`
+	Timestamp time.Time        `json:"timestamp"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Events    map[string]int64 `json:"events"`
+
+	path  string
+	dirty bool
+}
+
+// CheckpointDir returns the directory where checkpoints are stored
+func CheckpointDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "loro", "checkpoints"), nil
+}
+
+// LoadCheckpoint reads the named checkpoint from CheckpointDir. If it does not
+// exist yet, an empty checkpoint is returned.
+func LoadCheckpoint(name string) (*Checkpoint, error) {
+	if !checkpointNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid checkpoint name '%s', use only letters, numbers, '.', '_' and '-'", name)
+	}
+
+	dir, err := CheckpointDir()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{
+		Name:   name,
+		Events: map[string]int64{},
+		path:   filepath.Join(dir, name+".json"),
+	}
+
+	content, err := os.ReadFile(checkpoint.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint '%s': %w", checkpoint.path, err)
+	}
+	if checkpoint.Events == nil {
+		checkpoint.Events = map[string]int64{}
+	}
+
+	return checkpoint, nil
+}
+
+// Exists reports whether the checkpoint has recorded any event
+func (c *Checkpoint) Exists() bool {
+	return !c.Timestamp.IsZero()
+}
+
+// Start returns the time a resumed session should start reading from
+func (c *Checkpoint) Start() time.Time {
+	return c.Timestamp.Add(-CheckpointOverlap)
+}
+
+// EventIDs returns the IDs of the events recorded in the checkpoint
+func (c *Checkpoint) EventIDs() []string {
+	ids := make([]string, 0, len(c.Events))
+	for id := range c.Events {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Observe records an event as seen
+func (c *Checkpoint) Observe(event Event) {
+	if event.CreationTime.After(c.Timestamp) {
+		c.Timestamp = event.CreationTime
+	}
+	c.Events[event.ID] = event.CreationTime.UnixMilli()
+	c.dirty = true
+
+	// Prune down to 90% so it does not run again on every event
+	if len(c.Events) > MaxCheckpointEvents {
+		c.prune(MaxCheckpointEvents * 9 / 10)
+	}
+}
+
+// prune drops the event IDs that fall out of the overlap window, then the
+// oldest ones until at most max are left
+func (c *Checkpoint) prune(max int) {
+	oldest := c.Start().UnixMilli()
+	for id, ts := range c.Events {
+		if ts < oldest {
+			delete(c.Events, id)
+		}
+	}
+	if len(c.Events) <= max {
+		return
+	}
+
+	ids := c.EventIDs()
+	sort.Slice(ids, func(i, j int) bool {
+		if c.Events[ids[i]] != c.Events[ids[j]] {
+			return c.Events[ids[i]] < c.Events[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids[:len(ids)-max] {
+		delete(c.Events, id)
+	}
+}
+
+// Save writes the checkpoint to disk if it changed since it was last saved
+func (c *Checkpoint) Save() error {
+	if !c.dirty {
+		return nil
+	}
+
+	c.prune(MaxCheckpointEvents)
+	c.UpdatedAt = time.Now()
+
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so an interrupted write never
+	// leaves a corrupt checkpoint behind
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
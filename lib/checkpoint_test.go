@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// checkpointDir keeps the checkpoints of a test in a temporary directory
+func checkpointDir(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+}
+
+// observeAll reads every event of reader into checkpoint and returns their
+// messages
+func observeAll(t *testing.T, reader *CloudwatchLogsReader, checkpoint *Checkpoint) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	messages := []string{}
+	for event := range reader.StreamEvents(ctx, false) {
+		checkpoint.Observe(event)
+		messages = append(messages, event.Raw)
+	}
+	if err := reader.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	checkpointDir(t)
+
+	checkpoint, err := LoadCheckpoint("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Exists() {
+		t.Fatal("new checkpoint exists")
+	}
+	checkpoint.Observe(Event{ID: "old", CreationTime: testStart})
+	checkpoint.Observe(Event{ID: "recent", CreationTime: testStart.Add(2 * time.Minute)})
+	checkpoint.Observe(Event{ID: "newest", CreationTime: testStart.Add(3 * time.Minute)})
+	if err := checkpoint.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Exists() || !loaded.Timestamp.Equal(testStart.Add(3*time.Minute)) {
+		t.Errorf("got timestamp %s, want %s", loaded.Timestamp, testStart.Add(3*time.Minute))
+	}
+	if !loaded.Start().Equal(testStart.Add(3*time.Minute - CheckpointOverlap)) {
+		t.Errorf("got start %s", loaded.Start())
+	}
+	// Events outside the overlap window are pruned on save
+	if fmt.Sprint(len(loaded.Events), loaded.Events["recent"], loaded.Events["newest"]) !=
+		fmt.Sprint(2, testStart.Add(2*time.Minute).UnixMilli(), testStart.Add(3*time.Minute).UnixMilli()) {
+		t.Errorf("got events %v", loaded.Events)
+	}
+
+	if _, err := LoadCheckpoint("../api"); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
+
+// A resumed session reads again the overlap window, skipping the events seen
+// before the interruption but not the ones ingested late
+func TestCheckpointResume(t *testing.T) {
+	checkpointDir(t)
+
+	svc := NewMemoryLogSource()
+	for i := 0; i < 4; i++ {
+		svc.AddEvent("group", "api/1", testTime(i*30), fmt.Sprintf("event %d", i))
+	}
+
+	checkpoint, err := LoadCheckpoint("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalMessages(t, observeAll(t, newTestReader(t, svc, ""), checkpoint), "event 0", "event 1", "event 2", "event 3")
+	if err := checkpoint.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.AddEvent("group", "api/1", testTime(80), "late")
+	svc.AddEvent("group", "api/1", testTime(120), "new")
+
+	checkpoint, err = LoadCheckpoint("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewCloudwatchLogsReaderWithSource(svc, "group", "", checkpoint.Start(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.MarkSeen(checkpoint.EventIDs()...)
+	equalMessages(t, observeAll(t, reader, checkpoint), "late", "new")
+}
+
+// Past MaxCheckpointEvents the events outside the overlap, then the oldest
+// ones, are pruned down to 90% of it
+func TestCheckpointPrune(t *testing.T) {
+	max := MaxCheckpointEvents
+	MaxCheckpointEvents = 10
+	defer func() { MaxCheckpointEvents = max }()
+
+	checkpoint := &Checkpoint{Events: map[string]int64{}}
+	observe := func(from int, to int) {
+		for i := from; i <= to; i++ {
+			checkpoint.Observe(Event{ID: fmt.Sprintf("event-%02d", i), CreationTime: testStart.Add(time.Hour + time.Duration(i)*time.Second)})
+		}
+	}
+	kept := func(from int, to int) {
+		t.Helper()
+		if len(checkpoint.Events) != to-from+1 {
+			t.Errorf("got %d events, want %d", len(checkpoint.Events), to-from+1)
+		}
+		for i := from; i <= to; i++ {
+			if _, ok := checkpoint.Events[fmt.Sprintf("event-%02d", i)]; !ok {
+				t.Errorf("event-%02d was pruned", i)
+			}
+		}
+	}
+
+	checkpoint.Observe(Event{ID: "outside", CreationTime: testStart})
+	observe(0, 9)
+	kept(1, 9)
+
+	// Pruning waits for the checkpoint to grow past the limit again
+	observe(10, 10)
+	kept(1, 10)
+	observe(11, 11)
+	kept(3, 11)
+}
@@ -0,0 +1,311 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const (
+	// MaxContextEvents is the maximum number of events shown before or after
+	// a match
+	MaxContextEvents = 1000
+	// contextSlack is the number of extra events fetched around a match, so
+	// it can be found among other events logged in the same millisecond
+	contextSlack = 100
+	// contextPages is the maximum number of pages read on each side of a
+	// match, GetLogEvents can return empty pages before the end of a stream
+	contextPages = 10
+)
+
+// ContextSource is implemented by sources that can read the events of a
+// single stream, like *cloudwatchlogs.Client and MemoryLogSource
+type ContextSource interface {
+	cloudwatchlogs.GetLogEventsAPIClient
+}
+
+// EventsAround returns up to before events preceding event in its stream,
+// event itself and up to after events following it. The events around it
+// have Context set. It returns the position of event in the result.
+func (c *CloudwatchLogsReader) EventsAround(ctx context.Context, event Event, before int, after int) ([]Event, int, error) {
+	svc, ok := c.svc.(ContextSource)
+	if !ok {
+		return nil, 0, fmt.Errorf("context events are not supported by this source")
+	}
+
+	ts := event.CreationTime.UnixMilli()
+	message := event.Raw
+
+	// The latest events up to the timestamp of the match, which may include
+	// some logged after it in the same millisecond
+	preceding, err := c.streamEvents(ctx, svc, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(c.logGroupName),
+		LogStreamName: aws.String(event.Stream),
+		EndTime:       aws.Int64(ts + 1),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int32(int32(before + contextSlack)),
+	}, func(events []types.OutputLogEvent) bool {
+		ix := contextIndex(events, ts, message)
+		return ix < 0 || ix >= before
+	}, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	following, err := c.streamEvents(ctx, svc, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(c.logGroupName),
+		LogStreamName: aws.String(event.Stream),
+		StartTime:     aws.Int64(ts),
+		StartFromHead: aws.Bool(true),
+		Limit:         aws.Int32(int32(after + contextSlack)),
+	}, func(events []types.OutputLogEvent) bool {
+		ix := contextIndex(events, ts, message)
+		return ix < 0 || len(events)-ix-1 >= after
+	}, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Without the match in a page, it goes after the events logged before its
+	// timestamp and before the ones logged after it
+	ix := contextIndex(preceding, ts, message)
+	if ix < 0 {
+		ix = sort.Search(len(preceding), func(i int) bool { return aws.ToInt64(preceding[i].Timestamp) >= ts })
+	}
+	preceding = preceding[:ix]
+	if len(preceding) > before {
+		preceding = preceding[len(preceding)-before:]
+	}
+
+	ix = contextIndex(following, ts, message)
+	if ix < 0 {
+		ix = sort.Search(len(following), func(i int) bool { return aws.ToInt64(following[i].Timestamp) > ts }) - 1
+	}
+	following = following[ix+1:]
+	if len(following) > after {
+		following = following[:after]
+	}
+
+	window := make([]Event, 0, len(preceding)+1+len(following))
+	for _, e := range preceding {
+		window = append(window, c.contextEvent(event.Stream, e))
+	}
+	window = append(window, event)
+	for _, e := range following {
+		window = append(window, c.contextEvent(event.Stream, e))
+	}
+	return window, len(preceding), nil
+}
+
+// streamEvents reads pages of a stream, going backwards unless forward is
+// set, until done reports enough events were read or the stream ends
+func (c *CloudwatchLogsReader) streamEvents(ctx context.Context, svc ContextSource, params *cloudwatchlogs.GetLogEventsInput, done func([]types.OutputLogEvent) bool, forward bool) ([]types.OutputLogEvent, error) {
+	events := []types.OutputLogEvent{}
+	for page := 0; page < contextPages; page++ {
+		output, err := svc.GetLogEvents(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		next := output.NextBackwardToken
+		if forward {
+			events = append(events, output.Events...)
+			next = output.NextForwardToken
+		} else {
+			events = append(append([]types.OutputLogEvent{}, output.Events...), events...)
+		}
+
+		// The same token is returned at either end of the stream
+		if done(events) || next == nil || aws.ToString(next) == aws.ToString(params.NextToken) {
+			break
+		}
+		params.NextToken = next
+	}
+	return events, nil
+}
+
+// contextIndex returns the position of the event with the given timestamp
+// and message. Events logged in the same millisecond with the same message
+// can't be told apart, the match is taken as the first of them on both sides
+// so the others are shown once, after it.
+func contextIndex(events []types.OutputLogEvent, ts int64, message string) int {
+	for i, e := range events {
+		if aws.ToInt64(e.Timestamp) == ts && aws.ToString(e.Message) == message {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *CloudwatchLogsReader) contextEvent(stream string, e types.OutputLogEvent) Event {
+	event := NewEventWithParser(types.FilteredLogEvent{
+		EventId:       aws.String(""),
+		IngestionTime: e.IngestionTime,
+		LogStreamName: aws.String(stream),
+		Message:       e.Message,
+		Timestamp:     e.Timestamp,
+	}, c.logGroupName, c.parser)
+	event.Region = c.region
+	event.Account = c.account
+	event.Context = true
+	return event
+}
+
+// ContextGroup is a run of consecutive events of a stream holding one or more
+// matches and the events around them
+type ContextGroup struct {
+	Events []Event
+	// Continues is set when the group follows the previous group of the same
+	// stream without a gap
+	Continues bool
+}
+
+// pendingContext is a group that can still be extended by the next match of
+// its stream
+type pendingContext struct {
+	group   ContextGroup
+	updated time.Time
+}
+
+// ContextLines fetches the events around matches with GetLogEvents, joining
+// the windows of nearby matches of the same stream so no event is shown
+// twice
+type ContextLines struct {
+	before  int
+	after   int
+	readers map[string]*CloudwatchLogsReader
+	pending map[string]*pendingContext
+	last    map[string]Event
+}
+
+// NewContextLines returns a ContextLines showing up to before and after
+// events around each match
+func NewContextLines(before int, after int) (*ContextLines, error) {
+	if before < 0 || after < 0 || before > MaxContextEvents || after > MaxContextEvents {
+		return nil, fmt.Errorf("context must be between 0 and %d events", MaxContextEvents)
+	}
+
+	return &ContextLines{
+		before:  before,
+		after:   after,
+		readers: map[string]*CloudwatchLogsReader{},
+		pending: map[string]*pendingContext{},
+		last:    map[string]Event{},
+	}, nil
+}
+
+// AddReader registers the reader used to fetch the context of the events it
+// reads
+func (l *ContextLines) AddReader(reader *CloudwatchLogsReader) {
+	l.readers[contextKey(reader.account, reader.region, reader.logGroupName)] = reader
+}
+
+// Add fetches the context of a match and returns the groups completed by it
+func (l *ContextLines) Add(ctx context.Context, match Event) ([]ContextGroup, error) {
+	reader, ok := l.readers[contextKey(match.Account, match.Region, match.Group)]
+	if !ok {
+		return nil, fmt.Errorf("no reader for the group '%s' of the event", match.Group)
+	}
+
+	// One more event than needed tells whether the window follows the
+	// previous one of the stream without a gap
+	window, ix, err := reader.EventsAround(ctx, match, l.before+1, l.after)
+	if err != nil {
+		return nil, err
+	}
+
+	key := contextKey(match.Account, match.Region, match.Group, match.Stream)
+	completed := []ContextGroup{}
+
+	p, ok := l.pending[key]
+	if ok {
+		if j := lastIndexOf(window, p.group.Events[len(p.group.Events)-1]); j >= 0 {
+			// The window overlaps the pending group, the match may already be
+			// part of it as context
+			if k := lastIndexOf(p.group.Events, match); k >= 0 {
+				p.group.Events[k] = match
+			}
+			p.group.Events = append(p.group.Events, window[j+1:]...)
+			p.updated = time.Now()
+			return completed, nil
+		}
+		completed = append(completed, l.complete(key))
+	}
+
+	group := ContextGroup{}
+	if last, ok := l.last[key]; ok {
+		if j := lastIndexOf(window, last); j >= 0 {
+			group.Continues = true
+			window = window[j+1:]
+			ix -= j + 1
+		}
+	}
+	if !group.Continues && ix > l.before {
+		window = window[1:]
+	}
+	group.Events = window
+
+	l.pending[key] = &pendingContext{group: group, updated: time.Now()}
+	return completed, nil
+}
+
+// Expire returns the groups not extended for at least age
+func (l *ContextLines) Expire(age time.Duration) []ContextGroup {
+	keys := []string{}
+	for key, p := range l.pending {
+		if time.Since(p.updated) >= age {
+			keys = append(keys, key)
+		}
+	}
+	return l.completeAll(keys)
+}
+
+// Flush returns every pending group
+func (l *ContextLines) Flush() []ContextGroup {
+	keys := make([]string, 0, len(l.pending))
+	for key := range l.pending {
+		keys = append(keys, key)
+	}
+	return l.completeAll(keys)
+}
+
+// completeAll completes the groups of keys in order of their first event
+func (l *ContextLines) completeAll(keys []string) []ContextGroup {
+	sort.Slice(keys, func(i, j int) bool {
+		return l.pending[keys[i]].group.Events[0].CreationTime.Before(l.pending[keys[j]].group.Events[0].CreationTime)
+	})
+
+	groups := make([]ContextGroup, 0, len(keys))
+	for _, key := range keys {
+		groups = append(groups, l.complete(key))
+	}
+	return groups
+}
+
+func (l *ContextLines) complete(key string) ContextGroup {
+	group := l.pending[key].group
+	delete(l.pending, key)
+	l.last[key] = group.Events[len(group.Events)-1]
+	return group
+}
+
+func contextKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// lastIndexOf returns the position of the last event of events logged at the
+// same time and with the same message as event
+func lastIndexOf(events []Event, event Event) int {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].CreationTime.Equal(event.CreationTime) && events[i].Raw == event.Raw {
+			return i
+		}
+	}
+	return -1
+}
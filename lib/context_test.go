@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newContextTest returns ContextLines reading from a stream holding the given
+// messages, one per second, and the events of that stream
+func newContextTest(t *testing.T, before int, after int, messages ...string) (*ContextLines, []Event) {
+	t.Helper()
+	svc := NewMemoryLogSource()
+	for i, message := range messages {
+		svc.AddEvent("group", "api/1", testTime(i), message)
+	}
+
+	lines, err := NewContextLines(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines.AddReader(newTestReader(t, svc, ""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := []Event{}
+	for event := range newTestReader(t, svc, "").StreamEvents(ctx, false) {
+		events = append(events, event)
+	}
+	return lines, events
+}
+
+// formatGroups shows groups as [a *b c], with matches starred and groups
+// continuing the previous one starting with +
+func formatGroups(groups []ContextGroup) string {
+	formatted := []string{}
+	for _, group := range groups {
+		messages := []string{}
+		for _, event := range group.Events {
+			if event.Context {
+				messages = append(messages, event.Raw)
+			} else {
+				messages = append(messages, "*"+event.Raw)
+			}
+		}
+		prefix := ""
+		if group.Continues {
+			prefix = "+"
+		}
+		formatted = append(formatted, prefix+"["+strings.Join(messages, " ")+"]")
+	}
+	return strings.Join(formatted, " ")
+}
+
+func TestContextLines(t *testing.T) {
+	stream := []string{"e0", "e1", "e2", "e3", "e4", "e5", "e6", "e7", "e8", "e9"}
+	tests := []struct {
+		name    string
+		before  int
+		after   int
+		matches []int
+		want    string
+	}{
+		{name: "single", before: 2, after: 1, matches: []int{5}, want: "[e3 e4 *e5 e6]"},
+		{name: "overlapping", before: 1, after: 1, matches: []int{3, 4}, want: "[e2 *e3 *e4 e5]"},
+		{name: "within the context of another", before: 3, after: 3, matches: []int{3, 5}, want: "[e0 e1 e2 *e3 e4 *e5 e6 e7 e8]"},
+		{name: "adjacent", before: 1, after: 1, matches: []int{2, 5}, want: "[e1 *e2 e3 e4 *e5 e6]"},
+		{name: "apart", before: 1, after: 1, matches: []int{1, 6}, want: "[e0 *e1 e2] [e5 *e6 e7]"},
+		{name: "start of the stream", before: 3, after: 1, matches: []int{0}, want: "[*e0 e1]"},
+		{name: "end of the stream", before: 1, after: 3, matches: []int{9}, want: "[e8 *e9]"},
+		{name: "whole stream", before: 1, after: 1, matches: []int{0, 9}, want: "[*e0 e1] [e8 *e9]"},
+		{name: "no context", matches: []int{4, 6}, want: "[*e4] [*e6]"},
+		{name: "next to each other without context", matches: []int{4, 5}, want: "[*e4 *e5]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, events := newContextTest(t, test.before, test.after, stream...)
+
+			groups := []ContextGroup{}
+			for _, i := range test.matches {
+				completed, err := lines.Add(context.Background(), events[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				groups = append(groups, completed...)
+			}
+			groups = append(groups, lines.Flush()...)
+
+			if got := formatGroups(groups); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Groups completed by Expire are continued, not repeated, by the next match
+// of the stream
+func TestContextLinesExpire(t *testing.T) {
+	lines, events := newContextTest(t, 1, 1, "e0", "e1", "e2", "e3", "e4", "e5")
+
+	if _, err := lines.Add(context.Background(), events[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatGroups(lines.Expire(time.Hour)); got != "" {
+		t.Errorf("got %s expired, want none", got)
+	}
+	if got := formatGroups(lines.Expire(0)); got != "[e0 *e1 e2]" {
+		t.Errorf("got %s expired, want [e0 *e1 e2]", got)
+	}
+
+	if _, err := lines.Add(context.Background(), events[3]); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatGroups(lines.Flush()); got != "+[*e3 e4]" {
+		t.Errorf("got %s, want +[*e3 e4]", got)
+	}
+}
+
+// Pending groups of several streams complete in the order of their first event
+func TestContextLinesFlushOrder(t *testing.T) {
+	svc := NewMemoryLogSource()
+	for i := 0; i < 4; i++ {
+		svc.AddEvent("group", "b", testTime(i), fmt.Sprintf("b%d", i))
+		svc.AddEvent("group", "a", testTime(i+10), fmt.Sprintf("a%d", i))
+	}
+	lines, err := NewContextLines(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines.AddReader(newTestReader(t, svc, ""))
+
+	events := map[string]Event{}
+	for event := range newTestReader(t, svc, "").StreamEvents(context.Background(), false) {
+		events[event.Raw] = event
+	}
+	for _, match := range []string{"a2", "b2"} {
+		if _, err := lines.Add(context.Background(), events[match]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := formatGroups(lines.Flush()); got != "[b1 *b2] [a1 *a2]" {
+		t.Errorf("got %s, want [b1 *b2] [a1 *a2]", got)
+	}
+
+	if _, err := lines.Add(context.Background(), Event{Group: "other"}); err == nil {
+		t.Error("expected an error for an event of a group without reader")
+	}
+}
+
+// Events logged in the same millisecond with the same message can't be told
+// apart, each of them is shown once
+func TestContextLinesDuplicates(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(0), "a")
+	svc.AddEvent("group", "api/1", testTime(1), "retry")
+	svc.AddEvent("group", "api/1", testTime(1), "retry")
+	svc.AddEvent("group", "api/1", testTime(2), "b")
+
+	tests := []struct {
+		name    string
+		matches []int
+		want    string
+	}{
+		{name: "first", matches: []int{1}, want: "[a *retry retry b]"},
+		{name: "second", matches: []int{2}, want: "[a *retry retry b]"},
+		{name: "both", matches: []int{1, 2}, want: "[a *retry *retry b]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := NewContextLines(1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines.AddReader(newTestReader(t, svc, ""))
+
+			events := []Event{}
+			for event := range newTestReader(t, svc, "").StreamEvents(context.Background(), false) {
+				events = append(events, event)
+			}
+			for _, i := range test.matches {
+				if _, err := lines.Add(context.Background(), events[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := formatGroups(lines.Flush()); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	lru "github.com/hashicorp/golang-lru/v2"
@@ -24,16 +25,41 @@ var (
 	MaxStreams = 100
 )
 
+// LogSource is the subset of the CloudWatch Logs API used by the reader. It is
+// satisfied by *cloudwatchlogs.Client and by MemoryLogSource, which allows
+// plugging in other backends or testing without AWS access.
+type LogSource interface {
+	cloudwatchlogs.DescribeLogGroupsAPIClient
+	cloudwatchlogs.DescribeLogStreamsAPIClient
+	cloudwatchlogs.FilterLogEventsAPIClient
+}
+
 // CloudwatchLogsReader is responsible for fetching logs for a particular log
 // group
 type CloudwatchLogsReader struct {
-	logGroupName string
-	svc          *cloudwatchlogs.Client
-	eventCache   *lru.Cache[string, any]
-	start        time.Time
-	end          time.Time
-	error        error
-	streamPrefix string
+	logGroupName  string
+	svc           LogSource
+	eventCache    *lru.Cache[string, any]
+	start         time.Time
+	end           time.Time
+	error         error
+	streamPrefix  string
+	filterPattern string
+	liveTail      bool
+	lastTimestamp int64
+	lastIngestion time.Time
+	emitted       int
+	notify        func(string)
+	shards        int
+	workers       int
+	progress      func(BackfillProgress)
+	archive       *Archive
+	archiveFailed bool
+	local         bool
+	polled        time.Time
+	region        string
+	account       string
+	parser        Parser
 }
 
 // SetMaxStreams sets the maximum number of streams for describe/filter calls
@@ -44,7 +70,25 @@ func SetMaxStreams(max int) {
 // NewCloudwatchLogsReader takes a group and optionally a stream prefix, start and
 // end time, and returns a reader for any logs that match those parameters.
 func NewCloudwatchLogsReader(group string, streamPrefix string, start time.Time, end time.Time) (*CloudwatchLogsReader, error) {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	svc, err := NewCloudwatchLogsClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCloudwatchLogsReaderWithSource(svc, group, streamPrefix, start, end)
+}
+
+// NewCloudwatchLogsClient builds a CloudWatch Logs client from the AWS
+// configuration selected with SetAWSOptions. A single client can be shared by
+// several readers.
+func NewCloudwatchLogsClient(ctx context.Context) (*cloudwatchlogs.Client, error) {
+	return NewCloudwatchLogsClientWithOptions(ctx, awsOptions)
+}
+
+// NewCloudwatchLogsClientWithOptions builds a CloudWatch Logs client from the
+// AWS configuration selected by opts, e.g. to reach several regions at once
+func NewCloudwatchLogsClientWithOptions(ctx context.Context, opts AWSOptions) (*cloudwatchlogs.Client, error) {
+	cfg, err := LoadAWSConfig(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -52,8 +96,16 @@ func NewCloudwatchLogsReader(group string, streamPrefix string, start time.Time,
 	// Extend default retry count to 10
 	cfg.RetryMaxAttempts = 10
 
-	svc := cloudwatchlogs.NewFromConfig(cfg)
+	return cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+		if opts.EndpointURL != "" {
+			o.BaseEndpoint = aws.String(opts.EndpointURL)
+		}
+	}), nil
+}
 
+// NewCloudwatchLogsReaderWithSource behaves like NewCloudwatchLogsReader but
+// reads from the given LogSource instead of building an AWS client.
+func NewCloudwatchLogsReaderWithSource(svc LogSource, group string, streamPrefix string, start time.Time, end time.Time) (*CloudwatchLogsReader, error) {
 	// Twice the size of the MaxEventsPerCall to be on the safe side
 	cache, err := lru.New[string, any](MaxEventsPerCall * 2)
 	if err != nil {
@@ -67,19 +119,65 @@ func NewCloudwatchLogsReader(group string, streamPrefix string, start time.Time,
 		start:        start,
 		end:          end,
 		streamPrefix: streamPrefix,
+		notify:       func(string) {},
 	}
 
 	return reader, nil
 }
 
-// ListGroups returns a list of possible groups given a group name
+// SetFilterPattern sets a CloudWatch filter pattern that is applied server side
+// when streaming events. An empty pattern matches every event.
+func (c *CloudwatchLogsReader) SetFilterPattern(pattern string) error {
+	if err := ValidateFilterPattern(pattern); err != nil {
+		return err
+	}
+	c.filterPattern = pattern
+	return nil
+}
+
+// SetLiveTail makes StreamEvents use a CloudWatch Live Tail session instead of
+// polling when following. If the source does not support Live Tail, or the
+// session can not be started, the reader falls back to polling.
+func (c *CloudwatchLogsReader) SetLiveTail(enabled bool) {
+	c.liveTail = enabled
+}
+
+// SetNotifyFunc sets a function that receives informational messages about
+// the stream (e.g. falling back to polling) that are not errors
+func (c *CloudwatchLogsReader) SetNotifyFunc(notify func(string)) {
+	c.notify = notify
+}
+
+// SetParser sets the parser used for event messages, JSON by default
+func (c *CloudwatchLogsReader) SetParser(parser Parser) {
+	c.parser = parser
+}
+
+// SetOrigin sets the region and account every event is tagged with
+func (c *CloudwatchLogsReader) SetOrigin(region string, account string) {
+	c.region = region
+	c.account = account
+}
+
+// MarkSeen adds event IDs to the dedup cache so those events are not sent
+// again, e.g. when resuming from a checkpoint
+func (c *CloudwatchLogsReader) MarkSeen(ids ...string) {
+	for _, id := range ids {
+		c.eventCache.Add(id, nil)
+	}
+}
+
+// ListGroups returns a list of possible groups given a group name, or every
+// group when the name is empty
 func (c *CloudwatchLogsReader) ListGroups(ctx context.Context) ([]types.LogGroup, error) {
 	return getLogGroups(ctx, c.svc, c.logGroupName)
 }
 
-func getLogGroups(ctx context.Context, svc *cloudwatchlogs.Client, name string) ([]types.LogGroup, error) {
-	describeLogGroupsInput := &cloudwatchlogs.DescribeLogGroupsInput{
-		LogGroupNamePrefix: aws.String(name),
+func getLogGroups(ctx context.Context, svc LogSource, name string) ([]types.LogGroup, error) {
+	describeLogGroupsInput := &cloudwatchlogs.DescribeLogGroupsInput{}
+	// The API rejects an empty prefix
+	if name != "" {
+		describeLogGroupsInput.LogGroupNamePrefix = aws.String(name)
 	}
 
 	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(svc, describeLogGroupsInput)
@@ -97,12 +195,56 @@ func getLogGroups(ctx context.Context, svc *cloudwatchlogs.Client, name string)
 	return groups, nil
 }
 
+// ExpandLogGroups resolves a list of group names or glob patterns (e.g.
+// `/ecs/prod-*`) into the matching group names. Patterns use path.Match
+// syntax, so `*` does not match a `/`. Plain names are returned as they are.
+func ExpandLogGroups(ctx context.Context, svc LogSource, patterns []string) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		ix := strings.IndexAny(pattern, "*?[")
+		if ix < 0 {
+			add(pattern)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid group pattern '%s': %w", pattern, err)
+		}
+
+		groups, err := getLogGroups(ctx, svc, pattern[:ix])
+		if err != nil {
+			return nil, err
+		}
+
+		matched := false
+		for _, group := range groups {
+			if ok, _ := path.Match(pattern, *group.LogGroupName); ok {
+				matched = true
+				add(*group.LogGroupName)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no log groups found matching '%s'", pattern)
+		}
+	}
+
+	return names, nil
+}
+
 // GetGroup returns a selected group given a group name
 func (c *CloudwatchLogsReader) GetGroup(ctx context.Context) (types.LogGroup, error) {
 	return getLogGroup(ctx, c.svc, c.logGroupName)
 }
 
-func getLogGroup(ctx context.Context, svc *cloudwatchlogs.Client, name string) (types.LogGroup, error) {
+func getLogGroup(ctx context.Context, svc LogSource, name string) (types.LogGroup, error) {
 	groups, err := getLogGroups(ctx, svc, name)
 	if err != nil {
 		return types.LogGroup{}, err
@@ -198,14 +340,23 @@ func (c *CloudwatchLogsReader) getLogStreams(ctx context.Context) ([]types.LogSt
 
 	sort.Slice(streams[:], func(i, j int) bool { return *streams[i].LastIngestionTime > *streams[j].LastIngestionTime })
 	if len(streams) == 0 {
-		if c.streamPrefix != "" {
-			return nil, fmt.Errorf("no log streams found matching task prefix '%s' in your time window.  Consider adjusting your time window with --since and/or --until", c.streamPrefix)
-		}
+		return nil, &NoLogStreamsError{Prefix: c.streamPrefix}
+	}
+	return streams, nil
+}
 
-		return nil, errors.New("no log streams found in your time window.  Consider adjusting your time window with --since and/or --until")
+// NoLogStreamsError is returned when no log stream matches the reader params
+// in its time window
+type NoLogStreamsError struct {
+	Prefix string
+}
 
+func (e *NoLogStreamsError) Error() string {
+	if e.Prefix != "" {
+		return fmt.Sprintf("no log streams found matching task prefix '%s' in your time window.  Consider adjusting your time window with --since and/or --until", e.Prefix)
 	}
-	return streams, nil
+
+	return "no log streams found in your time window.  Consider adjusting your time window with --since and/or --until"
 }
 
 // StreamEvents returns a channel where you can read events matching the params
@@ -220,59 +371,208 @@ func (c *CloudwatchLogsReader) StreamEvents(ctx context.Context, follow bool) <-
 }
 
 func (c *CloudwatchLogsReader) pumpEvents(ctx context.Context, eventChan chan<- Event, follow bool) {
-	startTime := c.start.Unix() * 1e3
+	defer close(eventChan)
+
+	if c.archive != nil && c.local && !(follow && c.liveTail) {
+		c.error = c.localEvents(ctx, eventChan, follow)
+		return
+	}
+
+	params, err := c.filterParams(ctx, follow)
+	if err != nil {
+		c.error = err
+		return
+	}
+
+	if follow && c.liveTail {
+		c.error = c.liveTailEvents(ctx, eventChan, params)
+		return
+	}
+
+	c.error = c.fetchEvents(ctx, eventChan, params, follow)
+}
+
+// fetchEvents polls, or fetches in shards, the events matching params and
+// records the window in the archive if there is one
+func (c *CloudwatchLogsReader) fetchEvents(ctx context.Context, eventChan chan<- Event, params *cloudwatchlogs.FilterLogEventsInput, follow bool) error {
+	var err error
+	if !follow && c.shards > 1 {
+		started := time.Now()
+		err = c.shardEvents(ctx, eventChan, params)
+		c.polled = started
+	} else {
+		err = c.pollEvents(ctx, eventChan, params, follow)
+	}
+	c.recordCoverage(params, err, follow)
+	return err
+}
+
+// filterParams builds the FilterLogEvents input matching the reader params
+func (c *CloudwatchLogsReader) filterParams(ctx context.Context, follow bool) (*cloudwatchlogs.FilterLogEventsInput, error) {
+	startTime := c.start.UnixMilli()
 	params := &cloudwatchlogs.FilterLogEventsInput{
 		Interleaved:  aws.Bool(true),
 		LogGroupName: aws.String(c.logGroupName),
 		StartTime:    aws.Int64(startTime),
 	}
 
+	if c.filterPattern != "" {
+		params.FilterPattern = aws.String(c.filterPattern)
+	}
+
 	if !follow && c.end.IsZero() {
 		c.end = time.Now()
 	}
 
 	if !c.end.IsZero() {
-		endTime := c.end.Unix() * 1e3
+		endTime := c.end.UnixMilli()
 		params.EndTime = aws.Int64(endTime)
 	}
 
 	if c.streamPrefix != "" {
 		streams, err := c.getLogStreams(ctx)
 		if err != nil {
-			c.error = err
-			close(eventChan)
-			return
+			return nil, err
 		}
 		params.LogStreamNames = streamsToNames(streams)
 	}
 
+	return params, nil
+}
+
+// pollEvents pages through FilterLogEvents and sends any event not seen
+// before. When following, it keeps polling for new events until ctx is done,
+// adapting the interval to the event rate and retrying transient errors. Each
+// poll starts FollowOverlap before the newest event sent.
+func (c *CloudwatchLogsReader) pollEvents(ctx context.Context, eventChan chan<- Event, params *cloudwatchlogs.FilterLogEventsInput, follow bool) error {
+	if follow {
+		// Following moves the start of the window, leave the one of the
+		// caller as it is
+		window := *params
+		params = &window
+	}
+
+	poll := newPoller()
 	for {
-		paginator := cloudwatchlogs.NewFilterLogEventsPaginator(c.svc, params)
-		for paginator.HasMorePages() {
-			if page, err := paginator.NextPage(ctx); err != nil {
-				c.error = err
-				close(eventChan)
-				return
+		emitted := c.emitted
+		started := time.Now()
+		err := c.pollOnce(ctx, eventChan, params)
+		if err != nil {
+			if !follow || ctx.Err() != nil || !isTransientError(err) {
+				return err
+			}
+
+			wait := poll.failure(err)
+			if isThrottlingError(err) {
+				c.notify(fmt.Sprintf("throttled while fetching events, backing off for %s", wait.Round(time.Millisecond)))
 			} else {
-				params.NextToken = page.NextToken
-				for _, event := range page.Events {
-					if !c.eventCache.Contains(*event.EventId) {
-						eventChan <- NewEvent(event, c.logGroupName)
-						c.eventCache.Add(*event.EventId, nil)
-					}
-				}
+				c.notify(fmt.Sprintf("failed to fetch events (%s), retrying in %s", err, wait.Round(time.Millisecond)))
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
 			}
+			continue
 		}
 
+		c.polled = started
+
 		// If we are not following the logs, we are done
 		if !follow {
-			close(eventChan)
-			return
+			return nil
+		}
+
+		c.advanceStart(params, started)
+
+		wait := poll.success(c.emitted - emitted)
+		if poll.lagging(c.emitted-emitted, c.lastIngestion) {
+			c.notify(fmt.Sprintf("tail is lagging, newest event was ingested %s ago", time.Since(c.lastIngestion).Round(time.Second)))
 		}
 
 		// If we are following the logs, we need to wait for new events to appear
-		time.Sleep(100 * time.Millisecond)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// advanceStart moves the start of params to FollowOverlap before the newest
+// event sent, or before polled if that event is in the future, so the next
+// poll does not page again through the events already sent
+func (c *CloudwatchLogsReader) advanceStart(params *cloudwatchlogs.FilterLogEventsInput, polled time.Time) {
+	params.NextToken = nil
+	newest := c.lastTimestamp
+	if limit := polled.UnixMilli(); newest > limit {
+		newest = limit
 	}
+	if start := newest - FollowOverlap.Milliseconds(); start > aws.ToInt64(params.StartTime) {
+		params.StartTime = aws.Int64(start)
+	}
+}
+
+// pollOnce pages through all the events currently matching params
+func (c *CloudwatchLogsReader) pollOnce(ctx context.Context, eventChan chan<- Event, params *cloudwatchlogs.FilterLogEventsInput) error {
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(c.svc, params)
+	for paginator.HasMorePages() {
+		if page, err := paginator.NextPage(ctx); err != nil {
+			return err
+		} else {
+			params.NextToken = page.NextToken
+			for _, event := range page.Events {
+				if !c.emit(ctx, eventChan, event) {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// emit sends an event unless it was already sent before, storing it in the
+// archive if there is one. It returns false if ctx is done before the event
+// could be sent.
+func (c *CloudwatchLogsReader) emit(ctx context.Context, eventChan chan<- Event, event types.FilteredLogEvent) bool {
+	if c.liveTail {
+		// Live tail events carry no ID, so dedup on their content instead and
+		// use that as the ID of every event for consistency
+		event.EventId = aws.String(liveTailEventKey(event))
+	}
+
+	if c.archive != nil && !c.archiveFailed && !c.eventCache.Contains(*event.EventId) {
+		c.store(event)
+	}
+
+	return c.send(ctx, eventChan, event)
+}
+
+// send sends an event unless it was already sent before. It returns false if
+// ctx is done before the event could be sent.
+func (c *CloudwatchLogsReader) send(ctx context.Context, eventChan chan<- Event, event types.FilteredLogEvent) bool {
+	key := *event.EventId
+	if c.eventCache.Contains(key) {
+		return true
+	}
+
+	e := NewEventWithParser(event, c.logGroupName, c.parser)
+	e.Region = c.region
+	e.Account = c.account
+
+	select {
+	case eventChan <- e:
+	case <-ctx.Done():
+		return false
+	}
+	c.eventCache.Add(key, nil)
+
+	c.emitted++
+	if ts := aws.ToInt64(event.Timestamp); ts > c.lastTimestamp {
+		c.lastTimestamp = ts
+	}
+	if ingestion := ParseAWSTimestamp(event.IngestionTime); ingestion.After(c.lastIngestion) {
+		c.lastIngestion = ingestion
+	}
+
+	return true
 }
 
 // Error returns an error if one occurred while streaming events.
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// testStart is the start of the window of the test readers, an hour ago so
+// every event is older than the CheckpointOverlap of coverage
+var testStart = time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+// testTime returns the timestamp, in milliseconds, n seconds after testStart
+func testTime(n int) int64 {
+	return testStart.Add(time.Duration(n) * time.Second).UnixMilli()
+}
+
+func newTestReader(t *testing.T, svc LogSource, prefix string) *CloudwatchLogsReader {
+	t.Helper()
+	reader, err := NewCloudwatchLogsReaderWithSource(svc, "group", prefix, testStart, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+// eventMessage returns the message field of an event
+func eventMessage(event Event) string {
+	message, _ := event.Event["message"].(string)
+	return message
+}
+
+// readMessages reads every event of a one-shot read and returns their messages
+func readMessages(t *testing.T, reader *CloudwatchLogsReader) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	messages := []string{}
+	for event := range reader.StreamEvents(ctx, false) {
+		messages = append(messages, eventMessage(event))
+	}
+	if err := reader.Error(); err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	return messages
+}
+
+// receive reads n events from a following reader
+func receive(t *testing.T, events <-chan Event, n int) []string {
+	t.Helper()
+	messages := []string{}
+	timeout := time.After(5 * time.Second)
+	for len(messages) < n {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed after %v", messages)
+			}
+			messages = append(messages, eventMessage(event))
+		case <-timeout:
+			t.Fatalf("timed out after %v", messages)
+		}
+	}
+	return messages
+}
+
+// fastPolling makes following readers poll every few milliseconds
+func fastPolling(t *testing.T) {
+	min, max := MinPollInterval, MaxPollInterval
+	MinPollInterval, MaxPollInterval = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { MinPollInterval, MaxPollInterval = min, max })
+}
+
+func equalMessages(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetLogStreams(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(10), "a")
+	svc.AddEvent("group", "api/2", testTime(30), "b")
+	svc.AddEvent("group", "worker/1", testTime(20), "c")
+	svc.AddEvent("group", "old", testTime(-3600), "d")
+
+	tests := []struct {
+		name       string
+		prefix     string
+		maxStreams int
+		want       []string
+	}{
+		{name: "all active, most recent first", want: []string{"api/2", "worker/1", "api/1"}},
+		{name: "prefix", prefix: "api/", want: []string{"api/2", "api/1"}},
+		{name: "max streams", maxStreams: 2, want: []string{"api/2", "worker/1"}},
+		{name: "prefix and max streams", prefix: "api/", maxStreams: 1, want: []string{"api/1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.maxStreams > 0 {
+				max := MaxStreams
+				SetMaxStreams(test.maxStreams)
+				defer SetMaxStreams(max)
+			}
+
+			streams, err := newTestReader(t, svc, test.prefix).getLogStreams(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalMessages(t, streamsToNames(streams), test.want...)
+		})
+	}
+
+	t.Run("no streams", func(t *testing.T) {
+		_, err := newTestReader(t, svc, "missing/").getLogStreams(context.Background())
+		var noStreams *NoLogStreamsError
+		if !errors.As(err, &noStreams) || noStreams.Prefix != "missing/" {
+			t.Errorf("got %v, want a NoLogStreamsError", err)
+		}
+	})
+}
+
+func TestPumpEvents(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.PageSize = 2
+	svc.AddEvent("group", "api/1", testTime(1), "first")
+	svc.AddEvent("group", "api/2", testTime(2), "second")
+	svc.AddEvent("group", "worker/1", testTime(3), "third")
+	svc.AddEvent("group", "api/1", testTime(-60), "too old")
+
+	equalMessages(t, readMessages(t, newTestReader(t, svc, "")), "first", "second", "third")
+	equalMessages(t, readMessages(t, newTestReader(t, svc, "api/")), "first", "second")
+
+	reader := newTestReader(t, svc, "")
+	if err := reader.SetFilterPattern("second"); err != nil {
+		t.Fatal(err)
+	}
+	equalMessages(t, readMessages(t, reader), "second")
+}
+
+func TestPumpEventsFollow(t *testing.T) {
+	fastPolling(t)
+
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(1), "first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := newTestReader(t, svc, "")
+	events := reader.StreamEvents(ctx, true)
+	equalMessages(t, receive(t, events, 1), "first")
+
+	svc.AddEvent("group", "api/1", testTime(2), "second")
+	svc.AddEvent("group", "api/2", testTime(3), "third")
+	equalMessages(t, receive(t, events, 2), "second", "third")
+
+	cancel()
+	for event := range events {
+		t.Errorf("unexpected event after cancel: %s", eventMessage(event))
+	}
+	if err := reader.Error(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+// Follow polls read again the FollowOverlap before the newest event, the LRU
+// cache keeps the events already sent from being sent twice
+func TestPumpEventsDedup(t *testing.T) {
+	fastPolling(t)
+
+	svc := NewMemoryLogSource()
+	svc.PageSize = 1
+	for i := 0; i < 5; i++ {
+		svc.AddEvent("group", "api/1", testTime(i), fmt.Sprintf("event %d", i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := newTestReader(t, svc, "")
+	events := reader.StreamEvents(ctx, true)
+	equalMessages(t, receive(t, events, 5), "event 0", "event 1", "event 2", "event 3", "event 4")
+
+	// A late event older than the ones already sent is still sent once
+	svc.AddEvent("group", "api/1", testTime(2), "late")
+	svc.AddEvent("group", "api/1", testTime(10), "new")
+	equalMessages(t, receive(t, events, 2), "late", "new")
+
+	// Let a few more polls read the same window
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case event := <-events:
+		t.Errorf("event sent twice: %s", eventMessage(event))
+	default:
+	}
+	cancel()
+	for range events {
+	}
+
+	// Events marked as seen are skipped
+	reader = newTestReader(t, svc, "")
+	reader.MarkSeen(svc.AddEvent("group", "api/2", testTime(20), "seen"))
+	for _, message := range readMessages(t, reader) {
+		if message == "seen" {
+			t.Errorf("event marked as seen was sent")
+		}
+	}
+}
+
+// recordingSource records the start time of every filter call
+type recordingSource struct {
+	*MemoryLogSource
+
+	mu     sync.Mutex
+	starts []int64
+}
+
+func (r *recordingSource) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	r.mu.Lock()
+	r.starts = append(r.starts, aws.ToInt64(params.StartTime))
+	r.mu.Unlock()
+	return r.MemoryLogSource.FilterLogEvents(ctx, params, optFns...)
+}
+
+func (r *recordingSource) calls() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64{}, r.starts...)
+}
+
+// Once a follow poll is done, the next ones start FollowOverlap before the
+// newest event instead of paging again through the whole window
+func TestPumpEventsFollowWindow(t *testing.T) {
+	fastPolling(t)
+
+	svc := &recordingSource{MemoryLogSource: NewMemoryLogSource()}
+	svc.PageSize = 1
+	for i := 0; i < 5; i++ {
+		svc.AddEvent("group", "api/1", testTime(i*60), fmt.Sprintf("event %d", i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := newTestReader(t, svc, "")
+	events := reader.StreamEvents(ctx, true)
+	equalMessages(t, receive(t, events, 5), "event 0", "event 1", "event 2", "event 3", "event 4")
+
+	svc.AddEvent("group", "api/1", testTime(230), "late")
+	equalMessages(t, receive(t, events, 1), "late")
+	cancel()
+	for range events {
+	}
+
+	calls := svc.calls()
+	if len(calls) < 7 {
+		t.Fatalf("got %d filter calls, want a first poll and some more", len(calls))
+	}
+	for i, start := range calls[:5] {
+		if start != testTime(0) {
+			t.Errorf("call %d of the first poll starts at %d, want %d", i, start, testTime(0))
+		}
+	}
+	want := testTime(240) - FollowOverlap.Milliseconds()
+	for i, start := range calls[5:] {
+		if start != want {
+			t.Errorf("call %d starts at %d, want %d", i+5, start, want)
+		}
+	}
+}
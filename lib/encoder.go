@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Encoder writes events to an output in a given format
+type Encoder interface {
+	Encode(event Event) error
+}
+
+// EncoderOptions holds the settings shared by encoder constructors
+type EncoderOptions struct {
+	// Columns selects the values written by column based encoders (e.g. csv)
+	Columns []string
+}
+
+// EncoderFactory builds an Encoder writing to w
+type EncoderFactory func(w io.Writer, opts EncoderOptions) (Encoder, error)
+
+// DefaultColumns are the columns used by column based encoders when none are
+// given
+var DefaultColumns = []string{"time", "group", "stream", "message"}
+
+var encoders = map[string]EncoderFactory{
+	"jsonl": func(w io.Writer, opts EncoderOptions) (Encoder, error) {
+		return NewJSONLinesEncoder(w), nil
+	},
+	"logfmt": func(w io.Writer, opts EncoderOptions) (Encoder, error) {
+		return NewLogfmtEncoder(w), nil
+	},
+	"csv": func(w io.Writer, opts EncoderOptions) (Encoder, error) {
+		return NewCSVEncoder(w, opts.Columns)
+	},
+}
+
+// RegisterEncoder makes an output format available through NewEncoder
+func RegisterEncoder(name string, factory EncoderFactory) {
+	encoders[name] = factory
+}
+
+// Encoders returns the names of the registered output formats
+func Encoders() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEncoder returns an encoder for a registered output format
+func NewEncoder(name string, w io.Writer, opts EncoderOptions) (Encoder, error) {
+	factory, ok := encoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format '%s', expected one of: %s", name, strings.Join(Encoders(), ", "))
+	}
+	return factory(w, opts)
+}
+
+// TemplateEncoder renders each event with a text/template, one per line
+type TemplateEncoder struct {
+	w        io.Writer
+	template *template.Template
+}
+
+// NewTemplateEncoder returns an encoder rendering events with tmpl
+func NewTemplateEncoder(w io.Writer, tmpl *template.Template) *TemplateEncoder {
+	return &TemplateEncoder{w: w, template: tmpl}
+}
+
+// Encode implements Encoder
+func (e *TemplateEncoder) Encode(event Event) error {
+	if err := e.template.Execute(e.w, event); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(e.w, "\n")
+	return err
+}
+
+// JSONLinesEncoder writes each event as a compact JSON object on its own line
+type JSONLinesEncoder struct {
+	enc *json.Encoder
+}
+
+// jsonLinesRecord is the JSON representation of an event
+type jsonLinesRecord struct {
+	Timestamp     time.Time              `json:"timestamp"`
+	IngestionTime time.Time              `json:"ingestion_time"`
+	Group         string                 `json:"group"`
+	Stream        string                 `json:"stream"`
+	Region        string                 `json:"region,omitempty"`
+	Account       string                 `json:"account,omitempty"`
+	ID            string                 `json:"id"`
+	Context       bool                   `json:"context,omitempty"`
+	Offset        string                 `json:"offset,omitempty"`
+	Event         map[string]interface{} `json:"event"`
+}
+
+// NewJSONLinesEncoder returns an encoder writing JSON Lines
+func NewJSONLinesEncoder(w io.Writer) *JSONLinesEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONLinesEncoder{enc: enc}
+}
+
+// Encode implements Encoder
+func (e *JSONLinesEncoder) Encode(event Event) error {
+	return e.enc.Encode(jsonLinesRecord{
+		Timestamp:     event.CreationTime,
+		IngestionTime: event.IngestTime,
+		Group:         event.Group,
+		Stream:        event.Stream,
+		Region:        event.Region,
+		Account:       event.Account,
+		ID:            event.ID,
+		Context:       event.Context,
+		Offset:        event.Offset(),
+		Event:         event.Event,
+	})
+}
+
+// LogfmtEncoder writes each event as a logfmt line. Nested fields are
+// flattened using dotted keys.
+type LogfmtEncoder struct {
+	w io.Writer
+}
+
+// NewLogfmtEncoder returns an encoder writing logfmt
+func NewLogfmtEncoder(w io.Writer) *LogfmtEncoder {
+	return &LogfmtEncoder{w: w}
+}
+
+// Encode implements Encoder
+func (e *LogfmtEncoder) Encode(event Event) error {
+	var b strings.Builder
+	writePair := func(key string, value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(value))
+	}
+
+	writePair("time", event.CreationTime.Format(time.RFC3339Nano))
+	writePair("group", event.Group)
+	writePair("stream", event.Stream)
+	if event.Region != "" {
+		writePair("region", event.Region)
+	}
+	if event.Account != "" {
+		writePair("account", event.Account)
+	}
+	writePair("id", event.ID)
+	if event.Context {
+		writePair("context", "true")
+	}
+	if offset := event.Offset(); offset != "" {
+		writePair("offset", offset)
+	}
+
+	fields := map[string]string{}
+	flattenFields("", event.Event, fields)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writePair(key, fields[key])
+	}
+
+	b.WriteByte('\n')
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if strings.ContainsAny(value, " =\"\t\r\n\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// flattenFields stores every leaf value of fields in out using dotted keys
+func flattenFields(prefix string, fields map[string]interface{}, out map[string]string) {
+	for key, value := range fields {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenFields(key, nested, out)
+			continue
+		}
+		out[key] = FormatValue(value)
+	}
+}
+
+// CSVEncoder writes the selected columns of each event as a CSV record
+type CSVEncoder struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+// NewCSVEncoder returns an encoder writing CSV with the given columns. Columns
+// can be one of time, ingestion_time, group, stream, region, account or id,
+// or a dotted path into the event fields (e.g. message or request.path).
+func NewCSVEncoder(w io.Writer, columns []string) (*CSVEncoder, error) {
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+	for _, column := range columns {
+		if strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid empty column in '%s'", strings.Join(columns, ","))
+		}
+	}
+
+	return &CSVEncoder{w: csv.NewWriter(w), columns: columns}, nil
+}
+
+// Encode implements Encoder
+func (e *CSVEncoder) Encode(event Event) error {
+	if !e.header {
+		if err := e.w.Write(e.columns); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	record := make([]string, 0, len(e.columns))
+	for _, column := range e.columns {
+		record = append(record, event.Column(column))
+	}
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+
+	// Flush every record so output is not held back when following
+	e.w.Flush()
+	return e.w.Error()
+}
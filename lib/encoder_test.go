@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encoderEvent returns an event with nested fields and values that need
+// quoting in some formats
+func encoderEvent() Event {
+	created := time.Date(2024, 3, 1, 10, 0, 0, 500000000, time.UTC)
+	return Event{
+		Event: map[string]interface{}{
+			"message": `said "hi", then left`,
+			"level":   "info",
+			"empty":   "",
+			"request": map[string]interface{}{
+				"path":   "/a b",
+				"status": float64(200),
+				"ok":     true,
+			},
+			"tags": []interface{}{"x", "y"},
+		},
+		Group:        "group",
+		Stream:       "api/1",
+		Region:       "eu-west-1",
+		ID:           "42",
+		IngestTime:   created.Add(time.Second),
+		CreationTime: created,
+		Reference:    created.Add(-450 * time.Millisecond),
+	}
+}
+
+// encode writes events with the registered encoder name and returns the output
+func encode(t *testing.T, name string, opts EncoderOptions, events ...Event) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := NewEncoder(name, &buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestEncoders(t *testing.T) {
+	plain := Event{
+		Event:        map[string]interface{}{"message": "plain"},
+		Group:        "group",
+		Stream:       "api/2",
+		ID:           "43",
+		CreationTime: time.Date(2024, 3, 1, 10, 0, 1, 0, time.UTC),
+		Context:      true,
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		opts   EncoderOptions
+		want   string
+	}{
+		{
+			name:   "jsonl",
+			format: "jsonl",
+			want: `{"timestamp":"2024-03-01T10:00:00.5Z","ingestion_time":"2024-03-01T10:00:01.5Z","group":"group","stream":"api/1","region":"eu-west-1","id":"42","offset":"+450ms","event":{"empty":"","level":"info","message":"said \"hi\", then left","request":{"ok":true,"path":"/a b","status":200},"tags":["x","y"]}}` + "\n" +
+				`{"timestamp":"2024-03-01T10:00:01Z","ingestion_time":"0001-01-01T00:00:00Z","group":"group","stream":"api/2","id":"43","context":true,"event":{"message":"plain"}}` + "\n",
+		},
+		{
+			name:   "logfmt",
+			format: "logfmt",
+			want: `time=2024-03-01T10:00:00.5Z group=group stream=api/1 region=eu-west-1 id=42 offset=+450ms empty="" level=info message="said \"hi\", then left" request.ok=true request.path="/a b" request.status=200 tags="[\"x\",\"y\"]"` + "\n" +
+				`time=2024-03-01T10:00:01Z group=group stream=api/2 id=43 context=true message=plain` + "\n",
+		},
+		{
+			name:   "csv with default columns",
+			format: "csv",
+			want: "time,group,stream,message\n" +
+				`2024-03-01T10:00:00.5Z,group,api/1,"said ""hi"", then left"` + "\n" +
+				"2024-03-01T10:00:01Z,group,api/2,plain\n",
+		},
+		{
+			name:   "csv with columns",
+			format: "csv",
+			opts:   EncoderOptions{Columns: []string{"id", "ingestion_time", "region", "offset", "request.status", "request.path", "request", "tags", "missing"}},
+			want: "id,ingestion_time,region,offset,request.status,request.path,request,tags,missing\n" +
+				`42,2024-03-01T10:00:01.5Z,eu-west-1,+450ms,200,/a b,"{""ok"":true,""path"":""/a b"",""status"":200}","[""x"",""y""]",` + "\n" +
+				"43,0001-01-01T00:00:00Z,,,,,,,\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := encode(t, test.format, test.opts, encoderEvent(), plain); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// The CSV header is written once, before the first record, and not at all
+// without records
+func TestCSVEncoderHeader(t *testing.T) {
+	opts := EncoderOptions{Columns: []string{"id"}}
+	if got := encode(t, "csv", opts); got != "" {
+		t.Errorf("got %q without events", got)
+	}
+	if got := encode(t, "csv", opts, encoderEvent(), encoderEvent(), encoderEvent()); got != "id\n42\n42\n42\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestNewEncoderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	for _, columns := range [][]string{{"id", ""}, {" "}} {
+		if _, err := NewEncoder("csv", &buf, EncoderOptions{Columns: columns}); err == nil || !strings.Contains(err.Error(), "invalid empty column") {
+			t.Errorf("got error %v for columns %q", err, columns)
+		}
+	}
+	if _, err := NewEncoder("xml", &buf, EncoderOptions{}); err == nil || !strings.Contains(err.Error(), "csv, jsonl, logfmt") {
+		t.Errorf("got error %v for an unknown format", err)
+	}
+}
@@ -3,6 +3,8 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
@@ -13,28 +15,37 @@ type Event struct {
 	Event        map[string]interface{}
 	Stream       string
 	Group        string
+	Region       string
+	Account      string
 	ID           string
 	IngestTime   time.Time
 	CreationTime time.Time
+	// Raw is the original message
+	Raw string `json:"-"`
+	// Context is set on events shown around a match rather than matching
+	Context bool `json:",omitempty"`
+	// Reference is the time Offset is relative to, if any
+	Reference time.Time `json:"-"`
 }
 
 // NewEvent takes a cloudwatch log event and returns an Event
 func NewEvent(cwEvent types.FilteredLogEvent, group string) Event {
-	var ecsLogsEvent map[string]interface{}
-	if err := json.Unmarshal([]byte(*cwEvent.Message), &ecsLogsEvent); err != nil {
-		ecsLogsEvent = make(map[string]interface{})
-		ecsLogsEvent["message"] = *cwEvent.Message
-	}
+	return NewEventWithParser(cwEvent, group, nil)
+}
 
+// NewEventWithParser behaves like NewEvent but parses the message with parser
+// instead of JSON. Messages the parser does not understand are stored as is
+// under message.
+func NewEventWithParser(cwEvent types.FilteredLogEvent, group string, parser Parser) Event {
 	return Event{
-		Event:        ecsLogsEvent,
+		Event:        parseMessage(parser, *cwEvent.Message),
 		Stream:       *cwEvent.LogStreamName,
 		Group:        group,
 		ID:           *cwEvent.EventId,
 		IngestTime:   ParseAWSTimestamp(cwEvent.IngestionTime),
 		CreationTime: ParseAWSTimestamp(cwEvent.Timestamp),
+		Raw:          *cwEvent.Message,
 	}
-
 }
 
 // TimeShort gives the timestamp of an event in a readable format
@@ -42,6 +53,28 @@ func (e Event) TimeShort() string {
 	return e.CreationTime.Local().Format(ShortTimeFormat)
 }
 
+// Message returns the message of the event, or its fields as compact JSON
+// when it has none
+func (e Event) Message() string {
+	if message, ok := e.Event["message"].(string); ok {
+		return message
+	}
+	b, err := json.Marshal(e.Event)
+	if err != nil {
+		return fmt.Sprintf("%v", e.Event)
+	}
+	return string(b)
+}
+
+// Offset returns the time of the event relative to its Reference time (e.g.
+// +1.2s), or an empty string when it has none
+func (e Event) Offset() string {
+	if e.Reference.IsZero() {
+		return ""
+	}
+	return FormatOffset(e.CreationTime.Sub(e.Reference))
+}
+
 // PrettyPrint returns a formatted json from the full event
 func (e Event) PrettyPrint() string {
 	pretty, err := json.MarshalIndent(e, "", "  ")
@@ -51,3 +84,73 @@ func (e Event) PrettyPrint() string {
 
 	return string(pretty)
 }
+
+// Field returns the value of a parsed event field given its dotted path (e.g.
+// request.path for {"request": {"path": "/"}})
+func (e Event) Field(path string) (interface{}, bool) {
+	var current interface{} = e.Event
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = fields[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Column returns the value of a named column as a string. Columns can be one
+// of time, ingestion_time, group, stream, region, account, id or offset, or
+// a dotted path into the parsed event fields. Missing fields return an empty
+// string.
+func (e Event) Column(name string) string {
+	switch name {
+	case "time", "timestamp":
+		return e.CreationTime.Format(time.RFC3339Nano)
+	case "ingestion_time":
+		return e.IngestTime.Format(time.RFC3339Nano)
+	case "group":
+		return e.Group
+	case "stream":
+		return e.Stream
+	case "region":
+		return e.Region
+	case "account":
+		return e.Account
+	case "id":
+		return e.ID
+	case "offset":
+		return e.Offset()
+	}
+
+	value, ok := e.Field(name)
+	if !ok {
+		return ""
+	}
+	return FormatValue(value)
+}
+
+// FormatValue returns a parsed JSON value as a string, nested objects and
+// arrays are returned as compact JSON
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
+	}
+}
@@ -0,0 +1,556 @@
+package lib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// ExportChunk is the time span exported at once. Files never span more
+	// than a chunk, which is what makes exports resumable.
+	ExportChunk = 1 * time.Hour
+	// ManifestName is the name of the manifest file written in the export
+	// directory
+	ManifestName = "manifest.json"
+	// manifestInterval is how often the manifest is saved while only empty
+	// chunks are exported
+	manifestInterval = 10 * time.Second
+)
+
+// ExportOptions describes what to export and how to write it
+type ExportOptions struct {
+	Group  string
+	Prefix string
+	Filter string
+	Start  time.Time
+	End    time.Time
+	Dir    string
+	// Compression is one of gzip, zstd or none
+	Compression string
+	// Split is one of stream (a file per stream), hour (a file per hour) or
+	// size (files rotated after MaxSize bytes)
+	Split   string
+	MaxSize int64
+}
+
+// ExportManifest describes the content of an export directory
+type ExportManifest struct {
+	Group       string       `json:"group"`
+	Prefix      string       `json:"prefix,omitempty"`
+	Filter      string       `json:"filter,omitempty"`
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	Compression string       `json:"compression"`
+	Split       string       `json:"split"`
+	MaxSize     int64        `json:"max_size,omitempty"`
+	Complete    bool         `json:"complete"`
+	Events      int          `json:"events"`
+	ChunksDone  []time.Time  `json:"chunks_done"`
+	Files       []ExportFile `json:"files"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// ExportFile describes a single exported file
+type ExportFile struct {
+	Name   string    `json:"name"`
+	Stream string    `json:"stream,omitempty"`
+	Start  time.Time `json:"start"`
+	Events int       `json:"events"`
+	Bytes  int64     `json:"bytes"`
+	SHA256 string    `json:"sha256"`
+}
+
+// ExportProgress reports how far an export got
+type ExportProgress struct {
+	Chunks     int
+	ChunksDone int
+	Events     int
+}
+
+// Exporter downloads a window of logs into compressed JSON Lines files
+type Exporter struct {
+	svc      LogSource
+	opts     ExportOptions
+	manifest *ExportManifest
+	done     map[int64]bool
+}
+
+// NewExporter prepares a new export into opts.Dir. The directory must not
+// hold another export, use ResumeExporter to continue one.
+func NewExporter(svc LogSource, opts ExportOptions) (*Exporter, error) {
+	switch opts.Compression {
+	case "gzip", "zstd", "none":
+	default:
+		return nil, fmt.Errorf("unknown compression '%s', expected one of: gzip, zstd, none", opts.Compression)
+	}
+	switch opts.Split {
+	case "stream", "hour":
+	case "size":
+		if opts.MaxSize <= 0 {
+			return nil, errors.New("a positive max size is required to split by size")
+		}
+	default:
+		return nil, fmt.Errorf("unknown split '%s', expected one of: stream, hour, size", opts.Split)
+	}
+	if err := ValidateFilterPattern(opts.Filter); err != nil {
+		return nil, err
+	}
+	if !opts.Start.Before(opts.End) {
+		return nil, errors.New("the export window is empty, --since must be before --until")
+	}
+
+	manifest, err := readManifest(filepath.Join(opts.Dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		return nil, fmt.Errorf("'%s' already holds an export, resume it or use another directory", opts.Dir)
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		svc:  svc,
+		opts: opts,
+		done: map[int64]bool{},
+		manifest: &ExportManifest{
+			Group:       opts.Group,
+			Prefix:      opts.Prefix,
+			Filter:      opts.Filter,
+			Start:       opts.Start,
+			End:         opts.End,
+			Compression: opts.Compression,
+			Split:       opts.Split,
+			MaxSize:     opts.MaxSize,
+			ChunksDone:  []time.Time{},
+			Files:       []ExportFile{},
+			CreatedAt:   time.Now(),
+		},
+	}, nil
+}
+
+// ResumeExporter continues the partial export found in dir, using the
+// settings recorded in its manifest. Chunks already exported are skipped.
+func ResumeExporter(svc LogSource, dir string) (*Exporter, error) {
+	manifest, err := readManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("no export found in '%s'", dir)
+	}
+
+	e := &Exporter{
+		svc: svc,
+		opts: ExportOptions{
+			Group:       manifest.Group,
+			Prefix:      manifest.Prefix,
+			Filter:      manifest.Filter,
+			Start:       manifest.Start,
+			End:         manifest.End,
+			Dir:         dir,
+			Compression: manifest.Compression,
+			Split:       manifest.Split,
+			MaxSize:     manifest.MaxSize,
+		},
+		manifest: manifest,
+		done:     map[int64]bool{},
+	}
+	for _, chunk := range manifest.ChunksDone {
+		e.done[chunk.UnixMilli()] = true
+	}
+
+	return e, nil
+}
+
+// Manifest returns the export manifest
+func (e *Exporter) Manifest() *ExportManifest {
+	return e.manifest
+}
+
+// Run exports every chunk not exported yet, saving the manifest after each
+// one that wrote files. Files left behind by an interrupted chunk are replaced.
+func (e *Exporter) Run(ctx context.Context, progress func(ExportProgress)) error {
+	chunks, err := e.chunks(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := ExportProgress{Chunks: len(chunks), Events: e.manifest.Events}
+	saved := time.Now()
+	for _, chunk := range chunks {
+		if e.done[chunk.UnixMilli()] {
+			status.ChunksDone++
+			continue
+		}
+		if progress != nil {
+			progress(status)
+		}
+
+		start := chunk
+		if start.Before(e.opts.Start) {
+			start = e.opts.Start
+		}
+		end := chunk.Add(ExportChunk)
+		if end.After(e.opts.End) {
+			end = e.opts.End
+		}
+
+		files, err := e.exportChunk(ctx, start, end, func() {
+			status.Events++
+			if progress != nil && status.Events%1000 == 0 {
+				progress(status)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		e.manifest.Files = append(e.manifest.Files, files...)
+		for _, f := range files {
+			e.manifest.Events += f.Events
+		}
+		e.manifest.ChunksDone = append(e.manifest.ChunksDone, chunk)
+		e.done[chunk.UnixMilli()] = true
+		status.ChunksDone++
+		status.Events = e.manifest.Events
+
+		// Empty chunks are cheap to export again, only save them from time
+		// to time
+		if len(files) > 0 || time.Since(saved) >= manifestInterval {
+			if err := e.saveManifest(); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+
+	if progress != nil {
+		progress(status)
+	}
+
+	e.manifest.Complete = true
+	return e.saveManifest()
+}
+
+// chunks returns the start of every chunk of the window, aligned to the hour
+// so every file name stamp belongs to a single chunk. Windows starting before
+// the group was created, e.g. since all, start at its creation.
+func (e *Exporter) chunks(ctx context.Context) ([]time.Time, error) {
+	group, err := getLogGroup(ctx, e.svc, e.opts.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	start := e.opts.Start
+	if created := ParseAWSTimestamp(group.CreationTime); start.Before(created) {
+		start = created
+	}
+
+	chunks := []time.Time{}
+	for t := start.Truncate(ExportChunk); t.Before(e.opts.End); t = t.Add(ExportChunk) {
+		chunks = append(chunks, t)
+	}
+	return chunks, nil
+}
+
+// exportChunk writes the events in [start, end) and returns the written files
+func (e *Exporter) exportChunk(ctx context.Context, start time.Time, end time.Time, exported func()) ([]ExportFile, error) {
+	// End times are inclusive, stop right before the next chunk
+	reader, err := NewCloudwatchLogsReaderWithSource(e.svc, e.opts.Group, e.opts.Prefix, start, end.Add(-time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.SetFilterPattern(e.opts.Filter); err != nil {
+		return nil, err
+	}
+
+	if err := e.removeChunkFiles(start); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writers := map[string]*exportWriter{}
+	written := []ExportFile{}
+	closeAll := func() error {
+		keys := make([]string, 0, len(writers))
+		for key := range writers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			file, err := writers[key].Close()
+			if err != nil {
+				return err
+			}
+			written = append(written, file)
+			delete(writers, key)
+		}
+		return nil
+	}
+	abort := func(err error) ([]ExportFile, error) {
+		cancel()
+		for _, w := range writers {
+			w.Abort()
+		}
+		for _, f := range written {
+			os.Remove(filepath.Join(e.opts.Dir, f.Name))
+		}
+		return nil, err
+	}
+
+	part := 0
+	for event := range reader.StreamEvents(ctx, false) {
+		key := ""
+		if e.opts.Split == "stream" {
+			key = event.Stream
+		}
+
+		w, ok := writers[key]
+		if e.opts.Split == "size" && ok && w.size >= e.opts.MaxSize {
+			file, err := w.Close()
+			if err != nil {
+				return abort(err)
+			}
+			written = append(written, file)
+			delete(writers, key)
+			ok = false
+		}
+
+		if !ok {
+			name := e.fileName(start, key, part)
+			part++
+			w, err = newExportWriter(e.opts.Dir, name, e.opts.Compression)
+			if err != nil {
+				return abort(err)
+			}
+			w.file.Stream = key
+			w.file.Start = start
+			writers[key] = w
+		}
+
+		if err := w.Write(event); err != nil {
+			return abort(err)
+		}
+		exported()
+	}
+
+	if err := reader.Error(); err != nil {
+		var noStreams *NoLogStreamsError
+		if !errors.As(err, &noStreams) {
+			return abort(err)
+		}
+	}
+
+	if err := closeAll(); err != nil {
+		return abort(err)
+	}
+
+	return written, nil
+}
+
+// fileName returns the file name for a chunk and split key
+func (e *Exporter) fileName(start time.Time, key string, part int) string {
+	ext := ".jsonl"
+	switch e.opts.Compression {
+	case "gzip":
+		ext += ".gz"
+	case "zstd":
+		ext += ".zst"
+	}
+
+	stamp := start.UTC().Format("2006-01-02T15")
+	switch e.opts.Split {
+	case "stream":
+		return filepath.Join("streams", url.PathEscape(key), stamp+ext)
+	case "size":
+		return fmt.Sprintf("%s-%04d%s", stamp, part, ext)
+	}
+	return stamp + ext
+}
+
+// removeChunkFiles deletes files left behind by an interrupted export of the
+// chunk starting at start
+func (e *Exporter) removeChunkFiles(start time.Time) error {
+	stamp := start.UTC().Format("2006-01-02T15")
+	patterns := []string{
+		filepath.Join(e.opts.Dir, stamp+"*.jsonl*"),
+		filepath.Join(e.opts.Dir, "streams", "*", stamp+".jsonl*"),
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) saveManifest() error {
+	e.manifest.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(e.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(e.opts.Dir, ManifestName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readManifest(path string) (*ExportManifest, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &ExportManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest '%s': %w", path, err)
+	}
+	return manifest, nil
+}
+
+// exportWriter writes events as JSON Lines into a compressed file while
+// counting and hashing what ends up on disk
+type exportWriter struct {
+	path       string
+	file       ExportFile
+	size       int64
+	out        *os.File
+	hash       hash.Hash
+	counter    *countingWriter
+	compressor io.WriteCloser
+	buffer     *bufio.Writer
+	encoder    *JSONLinesEncoder
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newExportWriter(dir string, name string, compression string) (*exportWriter, error) {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	// Truncate whatever an interrupted run may have left
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &exportWriter{
+		path: path,
+		file: ExportFile{Name: name},
+		out:  out,
+		hash: sha256.New(),
+	}
+	w.counter = &countingWriter{w: io.MultiWriter(out, w.hash)}
+
+	switch compression {
+	case "gzip":
+		w.compressor = gzip.NewWriter(w.counter)
+	case "zstd":
+		w.compressor, err = zstd.NewWriter(w.counter)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+	default:
+		w.compressor = nopWriteCloser{w.counter}
+	}
+
+	w.buffer = bufio.NewWriter(w.compressor)
+	w.encoder = NewJSONLinesEncoder(&sizeWriter{w: w.buffer, n: &w.size})
+	return w, nil
+}
+
+// sizeWriter counts the uncompressed bytes written
+type sizeWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (s *sizeWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	*s.n += int64(n)
+	return n, err
+}
+
+func (w *exportWriter) Write(event Event) error {
+	if err := w.encoder.Encode(event); err != nil {
+		return err
+	}
+	w.file.Events++
+	return nil
+}
+
+// Close flushes and closes the file and returns its description
+func (w *exportWriter) Close() (ExportFile, error) {
+	if err := w.buffer.Flush(); err != nil {
+		w.Abort()
+		return ExportFile{}, err
+	}
+	if err := w.compressor.Close(); err != nil {
+		w.Abort()
+		return ExportFile{}, err
+	}
+	if err := w.out.Close(); err != nil {
+		os.Remove(w.path)
+		return ExportFile{}, err
+	}
+
+	w.file.Bytes = w.counter.n
+	w.file.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	return w.file, nil
+}
+
+// Abort closes and removes the file
+func (w *exportWriter) Abort() {
+	w.out.Close()
+	os.Remove(w.path)
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// failingSource fails filter calls starting at or after failFrom while fail is
+// set
+type failingSource struct {
+	*recordingSource
+	fail     bool
+	failFrom int64
+}
+
+func (f *failingSource) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if f.fail && aws.ToInt64(params.StartTime) >= f.failFrom {
+		return nil, errors.New("service unavailable")
+	}
+	return f.recordingSource.FilterLogEvents(ctx, params, optFns...)
+}
+
+// newExportSource returns a source with a group created half an hour into
+// base and events in the first and third hours after base
+func newExportSource(base time.Time) *failingSource {
+	svc := &failingSource{recordingSource: &recordingSource{MemoryLogSource: NewMemoryLogSource()}}
+	svc.AddGroup("group", base.Add(30*time.Minute).UnixMilli())
+	svc.AddEvent("group", "api/1", base.Add(40*time.Minute).UnixMilli(), "first")
+	svc.AddEvent("group", "api/1", base.Add(130*time.Minute).UnixMilli(), "second")
+	svc.AddEvent("group", "api/2", base.Add(140*time.Minute).UnixMilli(), "third")
+	return svc
+}
+
+func exportOptions(dir string, start time.Time, end time.Time) ExportOptions {
+	return ExportOptions{
+		Group:       "group",
+		Start:       start,
+		End:         end,
+		Dir:         dir,
+		Compression: "none",
+		Split:       "hour",
+	}
+}
+
+func fileNames(files []ExportFile) []string {
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func TestExport(t *testing.T) {
+	base := time.Now().Add(-5 * time.Hour).Truncate(time.Hour)
+	svc := newExportSource(base)
+	dir := t.TempDir()
+
+	// Since all starts at the creation of the group, in whole hours
+	exporter, err := NewExporter(svc, exportOptions(dir, time.Unix(0, 0), base.Add(4*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last ExportProgress
+	if err := exporter.Run(context.Background(), func(p ExportProgress) { last = p }); err != nil {
+		t.Fatal(err)
+	}
+	if last.Chunks != 4 || last.ChunksDone != 4 || last.Events != 3 {
+		t.Errorf("got progress %+v, want 4 chunks and 3 events", last)
+	}
+
+	manifest := exporter.Manifest()
+	if !manifest.Complete || manifest.Events != 3 {
+		t.Errorf("got complete %t with %d events, want a complete export of 3", manifest.Complete, manifest.Events)
+	}
+	stamp := func(hours int) string {
+		return base.Add(time.Duration(hours) * time.Hour).UTC().Format("2006-01-02T15")
+	}
+	equalMessages(t, fileNames(manifest.Files), stamp(0)+".jsonl", stamp(2)+".jsonl")
+
+	content, err := os.ReadFile(filepath.Join(dir, stamp(2)+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 2 {
+		t.Errorf("got %d lines in the third hour, want 2", lines)
+	}
+
+	// The manifest on disk is the one of the exporter
+	saved, err := readManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(manifest)
+	got, _ := json.Marshal(saved)
+	if string(got) != string(want) {
+		t.Errorf("got manifest %s, want %s", got, want)
+	}
+
+	if _, err := NewExporter(svc, exportOptions(dir, base, base.Add(time.Hour))); err == nil {
+		t.Error("expected an error exporting into a directory holding an export")
+	}
+}
+
+// A resumed export skips the chunks already done and replaces the files of
+// the interrupted one
+func TestExportResume(t *testing.T) {
+	base := time.Now().Add(-5 * time.Hour).Truncate(time.Hour)
+	svc := newExportSource(base)
+	svc.fail = true
+	svc.failFrom = base.Add(2 * time.Hour).UnixMilli()
+	dir := t.TempDir()
+
+	exporter, err := NewExporter(svc, exportOptions(dir, base.Add(-time.Hour), base.Add(4*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Run(context.Background(), nil); err == nil {
+		t.Fatal("expected the export to fail")
+	}
+
+	// Left behind by the interrupted chunk
+	stale := filepath.Join(dir, base.Add(2*time.Hour).UTC().Format("2006-01-02T15")+"-0000.jsonl")
+	if err := os.WriteFile(stale, []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.fail = false
+	calls := len(svc.calls())
+	exporter, err = ResumeExporter(svc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, start := range svc.calls()[calls:] {
+		if start < base.Add(time.Hour).UnixMilli() {
+			t.Errorf("the first chunk was fetched again from %s", time.UnixMilli(start))
+		}
+	}
+
+	manifest := exporter.Manifest()
+	if !manifest.Complete || manifest.Events != 3 || len(manifest.Files) != 2 {
+		t.Errorf("got complete %t with %d events in %v", manifest.Complete, manifest.Events, fileNames(manifest.Files))
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale file of the interrupted chunk was kept: %v", err)
+	}
+}
+
+func TestRemoveChunkFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	files := map[string]bool{
+		"2024-03-01T10.jsonl.gz":                  true,
+		"2024-03-01T10-0001.jsonl":                true,
+		"streams/api%2F1/2024-03-01T10.jsonl.zst": true,
+		"2024-03-01T11.jsonl.gz":                  false,
+		"streams/api%2F1/2024-03-01T09.jsonl.zst": false,
+		ManifestName:                              false,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exporter := &Exporter{opts: ExportOptions{Dir: dir}}
+	if err := exporter.removeChunkFiles(start); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, removed := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists == removed {
+			t.Errorf("%s: got exists %t, want %t", name, exists, !removed)
+		}
+	}
+}
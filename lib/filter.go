@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidateFilterPattern does a quick sanity check of a CloudWatch filter
+// pattern so obviously malformed patterns are reported before calling the
+// API. It does not implement the full filter syntax, it only checks that
+// quotes and brackets are balanced and that JSON patterns look like
+// `{ $.field = value }`.
+func ValidateFilterPattern(pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil
+	}
+
+	var stack []rune
+	inQuote := false
+	escaped := false
+	for i, r := range pattern {
+		if escaped {
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		if r == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote {
+			continue
+		}
+
+		switch r {
+		case '{', '[', '(':
+			stack = append(stack, r)
+		case '}', ']', ')':
+			open := map[rune]rune{'}': '{', ']': '[', ')': '('}[r]
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Errorf("invalid filter pattern '%s': unexpected '%c' at position %d", pattern, r, i+1)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if inQuote {
+		return fmt.Errorf("invalid filter pattern '%s': unterminated quoted term", pattern)
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("invalid filter pattern '%s': unclosed '%c'", pattern, stack[len(stack)-1])
+	}
+
+	switch pattern[0] {
+	case '{':
+		if pattern[len(pattern)-1] != '}' {
+			return fmt.Errorf("invalid filter pattern '%s': JSON patterns must be wrapped in '{ }'", pattern)
+		}
+		if !strings.Contains(pattern, "$.") {
+			return fmt.Errorf("invalid filter pattern '%s': JSON patterns must select a field with '$.' (e.g. { $.level = \"error\" })", pattern)
+		}
+	case '[':
+		if pattern[len(pattern)-1] != ']' {
+			return fmt.Errorf("invalid filter pattern '%s': space-delimited patterns must be wrapped in '[ ]'", pattern)
+		}
+		if strings.TrimSpace(pattern[1:len(pattern)-1]) == "" {
+			return errors.New("invalid filter pattern '[]': space-delimited patterns need at least one field")
+		}
+	}
+
+	return nil
+}
+
+// errNotLocalFilter is returned by localFilter for patterns it can not
+// evaluate
+var errNotLocalFilter = errors.New("only term patterns can be evaluated locally")
+
+// localFilter returns a function matching messages against a term based
+// filter pattern, the way CloudWatch does: every term must appear, terms
+// prefixed with - must not appear and, if any term is prefixed with ?, at
+// least one of those must appear. JSON and space-delimited patterns are not
+// supported.
+func localFilter(pattern string) (func(message string) bool, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	if pattern[0] == '{' || pattern[0] == '[' {
+		return nil, errNotLocalFilter
+	}
+
+	var required, excluded, optional []string
+	for _, term := range splitFilterTerms(pattern) {
+		switch {
+		case strings.HasPrefix(term, "-") && len(term) > 1:
+			excluded = append(excluded, unquoteFilterTerm(term[1:]))
+		case strings.HasPrefix(term, "?") && len(term) > 1:
+			optional = append(optional, unquoteFilterTerm(term[1:]))
+		default:
+			required = append(required, unquoteFilterTerm(term))
+		}
+	}
+
+	return func(message string) bool {
+		for _, term := range required {
+			if !strings.Contains(message, term) {
+				return false
+			}
+		}
+		for _, term := range excluded {
+			if strings.Contains(message, term) {
+				return false
+			}
+		}
+		for _, term := range optional {
+			if strings.Contains(message, term) {
+				return true
+			}
+		}
+		return len(optional) == 0
+	}, nil
+}
+
+// splitFilterTerms splits a pattern on spaces outside of quotes
+func splitFilterTerms(pattern string) []string {
+	var terms []string
+	var current strings.Builder
+	inQuote := false
+	for _, r := range pattern {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			current.WriteRune(r)
+		case r == ' ' && !inQuote:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms
+}
+
+func unquoteFilterTerm(term string) string {
+	if len(term) >= 2 && term[0] == '"' && term[len(term)-1] == '"' {
+		return term[1 : len(term)-1]
+	}
+	return term
+}
@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+var (
+	// QueryPollInterval is the time between GetQueryResults calls while a
+	// query is running
+	QueryPollInterval = 1 * time.Second
+)
+
+// InsightsSource is the subset of the CloudWatch Logs API used to run
+// CloudWatch Logs Insights queries. It is satisfied by *cloudwatchlogs.Client
+// and by MemoryInsightsSource.
+type InsightsSource interface {
+	StartQuery(ctx context.Context, params *cloudwatchlogs.StartQueryInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StartQueryOutput, error)
+	GetQueryResults(ctx context.Context, params *cloudwatchlogs.GetQueryResultsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetQueryResultsOutput, error)
+	StopQuery(ctx context.Context, params *cloudwatchlogs.StopQueryInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StopQueryOutput, error)
+}
+
+// InsightsQuery runs a CloudWatch Logs Insights query over one or more log
+// groups
+type InsightsQuery struct {
+	svc        InsightsSource
+	logGroups  []string
+	query      string
+	start      time.Time
+	end        time.Time
+	limit      int32
+	queryID    string
+	statistics types.QueryStatistics
+}
+
+// QueryResult holds the rows returned by an Insights query. Fields lists the
+// column names in the order they first appeared in the results.
+type QueryResult struct {
+	Status     types.QueryStatus
+	Statistics types.QueryStatistics
+	Fields     []string
+	Rows       []map[string]string
+}
+
+// QueryProgressFunc is called every time the status of a running query is
+// polled
+type QueryProgressFunc func(status types.QueryStatus, statistics types.QueryStatistics)
+
+// NewInsightsQuery takes a list of groups, a query string and a time window
+// and returns a query ready to be run.
+func NewInsightsQuery(groups []string, query string, start time.Time, end time.Time) (*InsightsQuery, error) {
+	svc, err := NewCloudwatchLogsClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewInsightsQueryWithSource(svc, groups, query, start, end)
+}
+
+// NewInsightsQueryWithSource behaves like NewInsightsQuery but runs the query
+// against the given InsightsSource.
+func NewInsightsQueryWithSource(svc InsightsSource, groups []string, query string, start time.Time, end time.Time) (*InsightsQuery, error) {
+	if len(groups) == 0 {
+		return nil, errors.New("at least one log group is required")
+	}
+	if query == "" {
+		return nil, errors.New("query string can not be empty")
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	return &InsightsQuery{
+		svc:       svc,
+		logGroups: groups,
+		query:     query,
+		start:     start,
+		end:       end,
+	}, nil
+}
+
+// SetLimit sets the maximum number of rows returned by the query, zero uses
+// the service default
+func (q *InsightsQuery) SetLimit(limit int32) {
+	q.limit = limit
+}
+
+// Run starts the query and polls for its results until it finishes. If ctx is
+// cancelled while the query is running, the query is stopped and whatever
+// partial results are available are returned with a Cancelled status.
+func (q *InsightsQuery) Run(ctx context.Context, progress QueryProgressFunc) (*QueryResult, error) {
+	params := &cloudwatchlogs.StartQueryInput{
+		LogGroupNames: q.logGroups,
+		QueryString:   aws.String(q.query),
+		StartTime:     aws.Int64(q.start.Unix()),
+		EndTime:       aws.Int64(q.end.Unix()),
+	}
+	if q.limit > 0 {
+		params.Limit = aws.Int32(q.limit)
+	}
+
+	started, err := q.svc.StartQuery(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	q.queryID = *started.QueryId
+
+	ticker := time.NewTicker(QueryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return q.stop()
+		case <-ticker.C:
+		}
+
+		output, err := q.svc.GetQueryResults(ctx, &cloudwatchlogs.GetQueryResultsInput{
+			QueryId: aws.String(q.queryID),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return q.stop()
+			}
+			return nil, err
+		}
+
+		if output.Statistics != nil {
+			q.statistics = *output.Statistics
+		}
+		if progress != nil {
+			progress(output.Status, q.statistics)
+		}
+
+		switch output.Status {
+		case types.QueryStatusComplete:
+			return newQueryResult(output.Status, q.statistics, output.Results), nil
+		case types.QueryStatusFailed, types.QueryStatusTimeout, types.QueryStatusCancelled, types.QueryStatusUnknown:
+			return nil, fmt.Errorf("query %s finished with status %s", q.queryID, output.Status)
+		}
+	}
+}
+
+// stop cancels the running query and fetches any partial results
+func (q *InsightsQuery) stop() (*QueryResult, error) {
+	// The caller context is already done, use a fresh one so we can still
+	// clean up the query on the service side
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := q.svc.StopQuery(ctx, &cloudwatchlogs.StopQueryInput{
+		QueryId: aws.String(q.queryID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stop query %s: %w", q.queryID, err)
+	}
+
+	output, err := q.svc.GetQueryResults(ctx, &cloudwatchlogs.GetQueryResultsInput{
+		QueryId: aws.String(q.queryID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Statistics != nil {
+		q.statistics = *output.Statistics
+	}
+
+	return newQueryResult(types.QueryStatusCancelled, q.statistics, output.Results), nil
+}
+
+func newQueryResult(status types.QueryStatus, statistics types.QueryStatistics, results [][]types.ResultField) *QueryResult {
+	result := &QueryResult{
+		Status:     status,
+		Statistics: statistics,
+		Fields:     []string{},
+		Rows:       make([]map[string]string, 0, len(results)),
+	}
+
+	seen := map[string]bool{}
+	for _, fields := range results {
+		row := make(map[string]string, len(fields))
+		for _, field := range fields {
+			name := aws.ToString(field.Field)
+			// @ptr is an opaque pointer to the log record, not useful for display
+			if name == "@ptr" {
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				result.Fields = append(result.Fields, name)
+			}
+			row[name] = aws.ToString(field.Value)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result
+}
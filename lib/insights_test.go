@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// fastQueries makes queries poll for results every millisecond
+func fastQueries(t *testing.T) {
+	interval := QueryPollInterval
+	QueryPollInterval = time.Millisecond
+	t.Cleanup(func() { QueryPollInterval = interval })
+}
+
+// resultRow returns a row of query results from field and value pairs
+func resultRow(pairs ...string) []types.ResultField {
+	row := []types.ResultField{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		row = append(row, types.ResultField{Field: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+	}
+	return row
+}
+
+func TestInsightsQuery(t *testing.T) {
+	fastQueries(t)
+
+	running := MemoryQueryPoll{Status: types.QueryStatusRunning, Statistics: types.QueryStatistics{RecordsScanned: 10}}
+	rows := [][]types.ResultField{
+		resultRow("@timestamp", "2024-03-01 10:00:00.000", "@message", "first", "@ptr", "abc"),
+		resultRow("@timestamp", "2024-03-01 10:00:01.000", "level", "error", "@message", "second"),
+	}
+
+	tests := []struct {
+		name    string
+		polls   []MemoryQueryPoll
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "complete",
+			polls: []MemoryQueryPoll{{Status: types.QueryStatusScheduled}, running, {Status: types.QueryStatusComplete, Results: rows}},
+			want:  "Complete [@timestamp @message level] [map[@message:first @timestamp:2024-03-01 10:00:00.000] map[@message:second @timestamp:2024-03-01 10:00:01.000 level:error]]",
+		},
+		{name: "complete without results", polls: []MemoryQueryPoll{{Status: types.QueryStatusComplete}}, want: "Complete [] []"},
+		{name: "failed", polls: []MemoryQueryPoll{running, {Status: types.QueryStatusFailed}}, wantErr: "finished with status Failed"},
+		{name: "timeout", polls: []MemoryQueryPoll{running, {Status: types.QueryStatusTimeout}}, wantErr: "finished with status Timeout"},
+		{name: "cancelled by the service", polls: []MemoryQueryPoll{{Status: types.QueryStatusCancelled}}, wantErr: "finished with status Cancelled"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := NewMemoryInsightsSource(test.polls...)
+			query, err := NewInsightsQueryWithSource(svc, []string{"group"}, "fields @message", testStart, testStart.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statuses := []types.QueryStatus{}
+			result, err := query.Run(context.Background(), func(status types.QueryStatus, statistics types.QueryStatistics) {
+				statuses = append(statuses, status)
+			})
+			if len(statuses) != len(test.polls) {
+				t.Errorf("got progress %v, want one call per poll", statuses)
+			}
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(result.Status, " ", result.Fields, " ", result.Rows); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Cancelling a running query stops it and returns the results found so far
+func TestInsightsQueryCancel(t *testing.T) {
+	fastQueries(t)
+
+	svc := NewMemoryInsightsSource(MemoryQueryPoll{
+		Status:     types.QueryStatusRunning,
+		Statistics: types.QueryStatistics{RecordsMatched: 1},
+		Results:    [][]types.ResultField{resultRow("@message", "partial")},
+	})
+	query, err := NewInsightsQueryWithSource(svc, []string{"a", "b"}, "fields @message", testStart, testStart.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.SetLimit(50)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result, err := query.Run(ctx, func(types.QueryStatus, types.QueryStatistics) { cancel() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != types.QueryStatusCancelled || len(result.Rows) != 1 || result.Rows[0]["@message"] != "partial" {
+		t.Errorf("got %s with %v, want the partial results", result.Status, result.Rows)
+	}
+	if result.Statistics.RecordsMatched != 1 {
+		t.Errorf("got statistics %+v", result.Statistics)
+	}
+
+	started := svc.Started()
+	if len(started) != 1 {
+		t.Fatalf("got %d queries started", len(started))
+	}
+	params := started[0]
+	if fmt.Sprint(params.LogGroupNames) != "[a b]" || aws.ToInt32(params.Limit) != 50 ||
+		aws.ToInt64(params.StartTime) != testStart.Unix() || aws.ToInt64(params.EndTime) != testStart.Add(time.Hour).Unix() {
+		t.Errorf("got query params %+v", params)
+	}
+}
+
+func TestNewInsightsQueryErrors(t *testing.T) {
+	svc := NewMemoryInsightsSource()
+	if _, err := NewInsightsQueryWithSource(svc, nil, "fields @message", testStart, time.Time{}); err == nil {
+		t.Error("expected an error without groups")
+	}
+	if _, err := NewInsightsQueryWithSource(svc, []string{"group"}, "", testStart, time.Time{}); err == nil {
+		t.Error("expected an error without a query")
+	}
+}
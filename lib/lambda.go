@@ -0,0 +1,368 @@
+package lib
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLambdaGroupTimeout is how long the events of an invocation are held
+// waiting for its REPORT line when grouping, the maximum Lambda run time
+const DefaultLambdaGroupTimeout = 15 * time.Minute
+
+var (
+	lambdaStartRegexp   = regexp.MustCompile(`^START RequestId: (\S+)(?: Version: (\S+))?`)
+	lambdaEndRegexp     = regexp.MustCompile(`^END RequestId: (\S+)`)
+	lambdaReportRegexp  = regexp.MustCompile(`(?s)^REPORT RequestId: (\S+)\s*(.*)$`)
+	lambdaTimeoutRegexp = regexp.MustCompile(`^(\S+) (\S+) Task timed out after ([\d.]+) seconds`)
+	// Node.js and Python runtime log lines
+	lambdaNodeRegexp   = regexp.MustCompile(`(?s)^(\d{4}-\d{2}-\d{2}T\S+)\t([0-9a-f-]{36})\t([A-Z]+)\t(.*)$`)
+	lambdaPythonRegexp = regexp.MustCompile(`(?s)^\[([A-Z]+)\]\t(\d{4}-\d{2}-\d{2}T\S+)\t([0-9a-f-]{36})\t(.*)$`)
+
+	// lambdaReportFields maps REPORT line metrics to field names
+	lambdaReportFields = map[string]string{
+		"Duration":         "duration_ms",
+		"Billed Duration":  "billed_duration_ms",
+		"Memory Size":      "memory_size_mb",
+		"Max Memory Used":  "max_memory_used_mb",
+		"Init Duration":    "init_duration_ms",
+		"Restore Duration": "restore_duration_ms",
+	}
+	// lambdaMetricFields maps the metrics of JSON platform.report events to
+	// the same field names
+	lambdaMetricFields = map[string]string{
+		"durationMs":        "duration_ms",
+		"billedDurationMs":  "billed_duration_ms",
+		"memorySizeMB":      "memory_size_mb",
+		"maxMemoryUsedMB":   "max_memory_used_mb",
+		"initDurationMs":    "init_duration_ms",
+		"restoreDurationMs": "restore_duration_ms",
+	}
+)
+
+func init() {
+	RegisterParser("lambda", NewLambdaParser(ParserFunc(parseAuto)))
+}
+
+// NewLambdaParser returns a parser for AWS Lambda logs. START, END and REPORT
+// lines, timeouts and runtime log lines get a type and a request_id, and
+// REPORT metrics are stored as numbers (e.g. duration_ms). Other messages are
+// parsed with fallback, copying their request ID to request_id if they have
+// one.
+func NewLambdaParser(fallback Parser) Parser {
+	return ParserFunc(func(message string) (map[string]interface{}, bool) {
+		if fields, ok := parseLambdaLine(message); ok {
+			return fields, true
+		}
+
+		fields, ok := fallback.Parse(message)
+		if !ok {
+			return nil, false
+		}
+		normalizeLambdaFields(fields)
+		return fields, true
+	})
+}
+
+// parseLambdaLine parses the lines written by the Lambda service and runtimes
+func parseLambdaLine(message string) (map[string]interface{}, bool) {
+	line := strings.TrimRight(message, "\r\n")
+
+	if match := lambdaStartRegexp.FindStringSubmatch(line); match != nil {
+		fields := map[string]interface{}{"type": "start", "request_id": match[1], "message": line}
+		if match[2] != "" {
+			fields["version"] = match[2]
+		}
+		return fields, true
+	}
+	if match := lambdaEndRegexp.FindStringSubmatch(line); match != nil {
+		return map[string]interface{}{"type": "end", "request_id": match[1], "message": line}, true
+	}
+	if match := lambdaReportRegexp.FindStringSubmatch(line); match != nil {
+		fields := map[string]interface{}{"type": "report", "request_id": match[1], "message": line}
+		// Traced functions add the XRAY line to the same event
+		metrics := strings.FieldsFunc(match[2], func(r rune) bool { return r == '\t' || r == '\n' })
+		for _, metric := range metrics {
+			name, value, ok := strings.Cut(strings.TrimSpace(metric), ": ")
+			if !ok {
+				continue
+			}
+			switch name {
+			case "XRAY TraceId":
+				fields["xray_trace_id"] = value
+			case "Status":
+				fields["status"] = value
+			default:
+				key, known := lambdaReportFields[name]
+				number, err := strconv.ParseFloat(strings.Fields(value + " ")[0], 64)
+				if known && err == nil {
+					fields[key] = number
+				}
+			}
+		}
+		fields["cold_start"] = fields["init_duration_ms"] != nil
+		return fields, true
+	}
+	if match := lambdaTimeoutRegexp.FindStringSubmatch(line); match != nil {
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		return map[string]interface{}{"type": "timeout", "timestamp": match[1], "request_id": match[2], "timeout_ms": seconds * 1000, "message": line}, true
+	}
+	if match := lambdaNodeRegexp.FindStringSubmatch(line); match != nil {
+		return map[string]interface{}{"type": "log", "timestamp": match[1], "request_id": match[2], "level": match[3], "message": match[4]}, true
+	}
+	if match := lambdaPythonRegexp.FindStringSubmatch(line); match != nil {
+		return map[string]interface{}{"type": "log", "level": match[1], "timestamp": match[2], "request_id": match[3], "message": match[4]}, true
+	}
+
+	return nil, false
+}
+
+// normalizeLambdaFields copies the request ID and metrics of structured
+// Lambda logs (JSON log format and application logs) to the fields used for
+// text logs
+func normalizeLambdaFields(fields map[string]interface{}) {
+	if record, ok := fields["record"].(map[string]interface{}); ok {
+		if id, ok := record["requestId"].(string); ok {
+			fields["request_id"] = id
+		}
+		if metrics, ok := record["metrics"].(map[string]interface{}); ok {
+			for name, key := range lambdaMetricFields {
+				if value, ok := metrics[name].(float64); ok {
+					fields[key] = value
+				}
+			}
+		}
+		if fields["type"] == "platform.report" {
+			fields["cold_start"] = fields["init_duration_ms"] != nil
+		}
+	}
+
+	if _, ok := fields["request_id"]; ok {
+		return
+	}
+	for _, key := range []string{"requestId", "AWSRequestId", "awsRequestId", "aws_request_id"} {
+		if id, ok := fields[key].(string); ok {
+			fields["request_id"] = id
+			return
+		}
+	}
+}
+
+// LambdaRequestID returns the Lambda request ID an event was tagged with
+func LambdaRequestID(event Event) string {
+	id, _ := event.Event["request_id"].(string)
+	return id
+}
+
+// isLambdaReport reports whether event is the REPORT of an invocation
+func isLambdaReport(event Event) bool {
+	kind, _ := event.Event["type"].(string)
+	return kind == "report" || kind == "platform.report"
+}
+
+func isLambdaStart(event Event) bool {
+	kind, _ := event.Event["type"].(string)
+	return kind == "start" || kind == "platform.start"
+}
+
+// LambdaOptions configures TrackLambdaInvocations
+type LambdaOptions struct {
+	// Group holds the events of each invocation and sends them together once
+	// its REPORT arrives
+	Group bool
+	// Timeout is how long an invocation is held waiting for its REPORT
+	Timeout time.Duration
+}
+
+// pendingInvocation holds the events of an invocation while grouping
+type pendingInvocation struct {
+	events   []Event
+	received time.Time
+}
+
+// TrackLambdaInvocations tags every event parsed with the Lambda parser with
+// the request ID of the invocation running in its stream when it was logged,
+// so plain prints of any runtime can be told apart. Optionally the events of
+// each invocation are sent together.
+func TrackLambdaInvocations(ctx context.Context, in <-chan Event, opts LambdaOptions) <-chan Event {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultLambdaGroupTimeout
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+
+		running := map[string]string{}
+		pending := map[string]*pendingInvocation{}
+		send := func(event Event) bool {
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		flush := func(id string) bool {
+			p, ok := pending[id]
+			if !ok {
+				return true
+			}
+			delete(pending, id)
+			for _, event := range p.events {
+				if !send(event) {
+					return false
+				}
+			}
+			return true
+		}
+
+		var tick <-chan time.Time
+		if opts.Group {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					ids := make([]string, 0, len(pending))
+					for id := range pending {
+						ids = append(ids, id)
+					}
+					sort.Slice(ids, func(i, j int) bool {
+						return pending[ids[i]].events[0].CreationTime.Before(pending[ids[j]].events[0].CreationTime)
+					})
+					for _, id := range ids {
+						if !flush(id) {
+							return
+						}
+					}
+					return
+				}
+
+				key := strings.Join([]string{event.Account, event.Region, event.Group, event.Stream}, "\x00")
+				id := LambdaRequestID(event)
+				switch {
+				case isLambdaStart(event) && id != "":
+					running[key] = id
+				case id == "" && running[key] != "" && event.Event != nil:
+					id = running[key]
+					event.Event["request_id"] = id
+				}
+				if isLambdaReport(event) {
+					delete(running, key)
+				}
+
+				if !opts.Group || id == "" {
+					if !send(event) {
+						return
+					}
+					continue
+				}
+
+				p, ok := pending[id]
+				if !ok {
+					p = &pendingInvocation{received: time.Now()}
+					pending[id] = p
+				}
+				p.events = append(p.events, event)
+				if isLambdaReport(event) && !flush(id) {
+					return
+				}
+			case now := <-tick:
+				for id, p := range pending {
+					if now.Sub(p.received) >= opts.Timeout && !flush(id) {
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// LambdaSummary aggregates the REPORT lines of a window
+type LambdaSummary struct {
+	Invocations     int
+	ColdStarts      int
+	Timeouts        int
+	MaxMemoryUsedMB float64
+	MemorySizeMB    float64
+	durations       []float64
+	initDurations   []float64
+	timedOut        map[string]bool
+}
+
+// Observe adds an event to the summary, only REPORT lines and timeouts count
+func (s *LambdaSummary) Observe(event Event) {
+	kind, _ := event.Event["type"].(string)
+	status, _ := event.Event["status"].(string)
+	if kind == "timeout" || status == "timeout" {
+		// Both the timeout line and the REPORT status may tell about it
+		if s.timedOut == nil {
+			s.timedOut = map[string]bool{}
+		}
+		if id := LambdaRequestID(event); !s.timedOut[id] || id == "" {
+			s.timedOut[id] = true
+			s.Timeouts++
+		}
+	}
+	if !isLambdaReport(event) {
+		return
+	}
+
+	s.Invocations++
+	if duration, ok := event.Event["duration_ms"].(float64); ok {
+		s.durations = append(s.durations, duration)
+	}
+	if init, ok := event.Event["init_duration_ms"].(float64); ok {
+		s.ColdStarts++
+		s.initDurations = append(s.initDurations, init)
+	}
+	if used, ok := event.Event["max_memory_used_mb"].(float64); ok && used > s.MaxMemoryUsedMB {
+		s.MaxMemoryUsedMB = used
+	}
+	if size, ok := event.Event["memory_size_mb"].(float64); ok && size > s.MemorySizeMB {
+		s.MemorySizeMB = size
+	}
+}
+
+// Duration returns the p-th percentile (0-100) of the invocation durations in
+// milliseconds
+func (s *LambdaSummary) Duration(p float64) float64 {
+	return percentile(s.durations, p)
+}
+
+// InitDuration returns the p-th percentile (0-100) of the cold start init
+// durations in milliseconds
+func (s *LambdaSummary) InitDuration(p float64) float64 {
+	return percentile(s.initDurations, p)
+}
+
+// percentile returns the nearest-rank percentile of values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
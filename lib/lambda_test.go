@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	lambdaCold    = "8f5a4c2e-1b3d-4e6f-9a0b-1c2d3e4f5a6b"
+	lambdaWarm    = "0d6b7c1a-2e4f-4a8b-9c3d-5e6f7a8b9c0d"
+	lambdaTimeout = "c3e1f2a4-5b6c-4d7e-8f9a-0b1c2d3e4f5a"
+)
+
+// lambdaLines are real Lambda logs of a stream: a cold start, a warm
+// invocation logging with the Node.js runtime and a timeout
+var lambdaLines = []string{
+	"INIT_START Runtime Version: nodejs:18.v20\tRuntime Version ARN: arn:aws:lambda:eu-west-1::runtime:0a1b\n",
+	"START RequestId: " + lambdaCold + " Version: $LATEST\n",
+	"loading config\n",
+	"END RequestId: " + lambdaCold + "\n",
+	"REPORT RequestId: " + lambdaCold + "\tDuration: 102.25 ms\tBilled Duration: 103 ms\tMemory Size: 128 MB\tMax Memory Used: 67 MB\tInit Duration: 245.19 ms\t\nXRAY TraceId: 1-65e1a2b3-4c5d6e7f8a9b0c1d2e3f4a5b\tSegmentId: 1a2b3c4d5e6f7a8b\tSampled: true\t\n",
+	"START RequestId: " + lambdaWarm + " Version: 7\n",
+	"2024-03-01T10:00:02.120Z\t" + lambdaWarm + "\tINFO\thandled /orders\n",
+	"END RequestId: " + lambdaWarm + "\n",
+	"REPORT RequestId: " + lambdaWarm + "\tDuration: 20.50 ms\tBilled Duration: 21 ms\tMemory Size: 128 MB\tMax Memory Used: 70 MB\t\n",
+	"START RequestId: " + lambdaTimeout + " Version: 7\n",
+	"waiting on the database\n",
+	"2024-03-01T10:00:06.130Z " + lambdaTimeout + " Task timed out after 3.00 seconds\n",
+	"END RequestId: " + lambdaTimeout + "\n",
+	"REPORT RequestId: " + lambdaTimeout + "\tDuration: 3000.00 ms\tBilled Duration: 3000 ms\tMemory Size: 128 MB\tMax Memory Used: 71 MB\tStatus: timeout\n",
+}
+
+// lambdaEvents parses messages with the Lambda parser into events of stream,
+// one second apart
+func lambdaEvents(stream string, messages ...string) []Event {
+	parser := NewLambdaParser(ParserFunc(parseJSON))
+	events := make([]Event, 0, len(messages))
+	for i, message := range messages {
+		events = append(events, Event{
+			Event:        parseMessage(parser, message),
+			Group:        "/aws/lambda/orders",
+			Stream:       stream,
+			CreationTime: testStart.Add(time.Duration(i) * time.Second),
+			Raw:          message,
+		})
+	}
+	return events
+}
+
+func TestLambdaParser(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{lambdaLines[1], "map[message:START RequestId: " + lambdaCold + " Version: $LATEST request_id:" + lambdaCold + " type:start version:$LATEST]"},
+		{lambdaLines[3], "map[message:END RequestId: " + lambdaCold + " request_id:" + lambdaCold + " type:end]"},
+		{lambdaLines[4], "cold_start:true billed_duration_ms:103 duration_ms:102.25 init_duration_ms:245.19 max_memory_used_mb:67 memory_size_mb:128 request_id:" + lambdaCold + " type:report"},
+		{lambdaLines[8], "cold_start:false billed_duration_ms:21 duration_ms:20.5 init_duration_ms:<nil> max_memory_used_mb:70 memory_size_mb:128 request_id:" + lambdaWarm + " type:report"},
+		{lambdaLines[13], "cold_start:false billed_duration_ms:3000 duration_ms:3000 init_duration_ms:<nil> max_memory_used_mb:71 memory_size_mb:128 request_id:" + lambdaTimeout + " type:report"},
+		{lambdaLines[6], "map[level:INFO message:handled /orders request_id:" + lambdaWarm + " timestamp:2024-03-01T10:00:02.120Z type:log]"},
+		{lambdaLines[11], "map[message:2024-03-01T10:00:06.130Z " + lambdaTimeout + " Task timed out after 3.00 seconds request_id:" + lambdaTimeout + " timeout_ms:3000 timestamp:2024-03-01T10:00:06.130Z type:timeout]"},
+		{"[ERROR]\t2024-03-01T10:00:02.120Z\t" + lambdaWarm + "\tboom\n", "map[level:ERROR message:boom request_id:" + lambdaWarm + " timestamp:2024-03-01T10:00:02.120Z type:log]"},
+		{`{"type":"platform.report","record":{"requestId":"` + lambdaCold + `","metrics":{"durationMs":102.25,"initDurationMs":245.19}}}`, "cold_start:true billed_duration_ms:<nil> duration_ms:102.25 init_duration_ms:245.19 max_memory_used_mb:<nil> memory_size_mb:<nil> request_id:" + lambdaCold + " type:platform.report"},
+		{`{"msg":"done","awsRequestId":"` + lambdaWarm + `"}`, "map[awsRequestId:" + lambdaWarm + " msg:done request_id:" + lambdaWarm + "]"},
+	}
+	metrics := []string{"cold_start", "billed_duration_ms", "duration_ms", "init_duration_ms", "max_memory_used_mb", "memory_size_mb", "request_id", "type"}
+	for _, test := range tests {
+		fields := lambdaEvents("stream", test.message)[0].Event
+		got := fmt.Sprint(fields)
+		if kind := fields["type"]; kind == "report" || kind == "platform.report" {
+			pairs := []string{}
+			for _, key := range metrics {
+				pairs = append(pairs, fmt.Sprintf("%s:%v", key, fields[key]))
+			}
+			got = strings.Join(pairs, " ")
+		}
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.message, got, test.want)
+		}
+	}
+	if fields := lambdaEvents("stream", lambdaLines[4])[0].Event; fields["xray_trace_id"] != "1-65e1a2b3-4c5d6e7f8a9b0c1d2e3f4a5b" {
+		t.Errorf("got xray_trace_id %v", fields["xray_trace_id"])
+	}
+}
+
+// trackLambda runs events through TrackLambdaInvocations and returns each
+// output event as the start of its request ID and its type or message
+func trackLambda(t *testing.T, events []Event, opts LambdaOptions) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	in := make(chan Event, len(events))
+	for _, event := range events {
+		in <- event
+	}
+	close(in)
+
+	got := []string{}
+	for event := range TrackLambdaInvocations(ctx, in, opts) {
+		label, ok := event.Event["type"].(string)
+		if !ok {
+			label = strings.TrimSpace(event.Message())
+		}
+		got = append(got, fmt.Sprintf("%.8s %s", LambdaRequestID(event), label))
+	}
+	if ctx.Err() != nil {
+		t.Fatal("timed out")
+	}
+	return got
+}
+
+func TestTrackLambdaInvocations(t *testing.T) {
+	a := lambdaEvents("2024/03/01/[$LATEST]a", lambdaLines[1:5]...)
+	b := lambdaEvents("2024/03/01/[7]b", lambdaLines[5:9]...)
+	// Two concurrent execution environments followed by a timeout and an
+	// invocation cut short by the end of the window
+	events := []Event{a[0], b[0], a[1], b[1], a[2], b[2], a[3], b[3]}
+	events = append(events, lambdaEvents("2024/03/01/[$LATEST]a", lambdaLines[9:]...)...)
+	events = append(events, lambdaEvents("2024/03/01/[$LATEST]a", "START RequestId: 5e6f7a8b-0000-4000-8000-000000000000\n", "still running\n")...)
+
+	tests := []struct {
+		name string
+		opts LambdaOptions
+		want []string
+	}{
+		{
+			name: "tagged",
+			want: []string{
+				"8f5a4c2e start", "0d6b7c1a start", "8f5a4c2e loading config", "0d6b7c1a log",
+				"8f5a4c2e end", "0d6b7c1a end", "8f5a4c2e report", "0d6b7c1a report",
+				"c3e1f2a4 start", "c3e1f2a4 waiting on the database", "c3e1f2a4 timeout", "c3e1f2a4 end", "c3e1f2a4 report",
+				"5e6f7a8b start", "5e6f7a8b still running",
+			},
+		},
+		{
+			name: "grouped",
+			opts: LambdaOptions{Group: true},
+			want: []string{
+				"8f5a4c2e start", "8f5a4c2e loading config", "8f5a4c2e end", "8f5a4c2e report",
+				"0d6b7c1a start", "0d6b7c1a log", "0d6b7c1a end", "0d6b7c1a report",
+				"c3e1f2a4 start", "c3e1f2a4 waiting on the database", "c3e1f2a4 timeout", "c3e1f2a4 end", "c3e1f2a4 report",
+				"5e6f7a8b start", "5e6f7a8b still running",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The parsed fields are tagged in place, parse them again
+			events := append([]Event(nil), events...)
+			for i := range events {
+				events[i].Event = lambdaEvents(events[i].Stream, events[i].Raw)[0].Event
+			}
+			equalMessages(t, trackLambda(t, events, test.opts), test.want...)
+		})
+	}
+}
+
+func TestLambdaSummary(t *testing.T) {
+	summary := &LambdaSummary{}
+	for _, event := range lambdaEvents("stream", lambdaLines...) {
+		summary.Observe(event)
+	}
+	got := fmt.Sprint(summary.Invocations, summary.ColdStarts, summary.Timeouts, summary.MaxMemoryUsedMB, summary.MemorySizeMB)
+	if got != "3 1 1 71 128" {
+		t.Errorf("got invocations, cold starts, timeouts, max memory and memory size %s", got)
+	}
+
+	percentiles := []struct {
+		p    float64
+		want float64
+	}{{0, 20.5}, {33, 20.5}, {34, 102.25}, {50, 102.25}, {67, 3000}, {99, 3000}, {100, 3000}}
+	for _, test := range percentiles {
+		if got := summary.Duration(test.p); got != test.want {
+			t.Errorf("got p%.0f duration %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := summary.InitDuration(50); got != 245.19 {
+		t.Errorf("got p50 init duration %v", got)
+	}
+	if got := (&LambdaSummary{}).Duration(50); got != 0 {
+		t.Errorf("got p50 duration %v without invocations", got)
+	}
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const (
+	// liveTailCatchUp is how far back from the last seen event we poll when a
+	// live tail session (re)starts, to pick up events ingested late or while
+	// no session was open
+	liveTailCatchUp = 1 * time.Minute
+)
+
+// LiveTailSource is implemented by sources that support CloudWatch Live Tail
+// sessions, like *cloudwatchlogs.Client.
+type LiveTailSource interface {
+	StartLiveTail(ctx context.Context, params *cloudwatchlogs.StartLiveTailInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StartLiveTailOutput, error)
+}
+
+// liveTailEvents streams events through Live Tail sessions. It first fetches
+// the requested history by polling, then opens a session and, every time a
+// session starts (sessions end after 3 hours), polls the gap since the last
+// seen event so nothing is lost between sessions.
+func (c *CloudwatchLogsReader) liveTailEvents(ctx context.Context, eventChan chan<- Event, params *cloudwatchlogs.FilterLogEventsInput) error {
+	svc, ok := c.svc.(LiveTailSource)
+	if !ok {
+		c.notify("live tail is not supported by this source, falling back to polling")
+		return c.pollEvents(ctx, eventChan, params, true)
+	}
+
+	group, err := getLogGroup(ctx, c.svc, c.logGroupName)
+	if err != nil {
+		return err
+	}
+
+	// Live tail wants the group ARN without the trailing ':*'
+	input := &cloudwatchlogs.StartLiveTailInput{
+		LogGroupIdentifiers:   []string{strings.TrimSuffix(aws.ToString(group.Arn), ":*")},
+		LogEventFilterPattern: params.FilterPattern,
+	}
+	if c.streamPrefix != "" {
+		input.LogStreamNamePrefixes = []string{c.streamPrefix}
+	}
+
+	backfill := *params
+	backfill.EndTime = aws.Int64(time.Now().UnixMilli())
+	if err := c.pollEvents(ctx, eventChan, &backfill, false); err != nil {
+		return err
+	}
+	if c.lastTimestamp == 0 {
+		c.lastTimestamp = *backfill.EndTime
+	}
+
+	for {
+		output, err := svc.StartLiveTail(ctx, input)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.notify(fmt.Sprintf("live tail is not available (%s), falling back to polling", err))
+			catchUp := *params
+			catchUp.NextToken = nil
+			catchUp.StartTime = aws.Int64(c.lastTimestamp - liveTailCatchUp.Milliseconds())
+			return c.pollEvents(ctx, eventChan, &catchUp, true)
+		}
+
+		stream := output.GetStream()
+		err = c.consumeLiveTail(ctx, eventChan, stream, params)
+		stream.Close()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var timeout *types.SessionTimeoutException
+		if err != nil && !errors.As(err, &timeout) {
+			return err
+		}
+
+		c.notify("live tail session ended, starting a new one")
+	}
+}
+
+// consumeLiveTail polls the gap since the last seen event and then sends the
+// events received from a live tail session until the session ends
+func (c *CloudwatchLogsReader) consumeLiveTail(ctx context.Context, eventChan chan<- Event, stream *cloudwatchlogs.StartLiveTailEventStream, params *cloudwatchlogs.FilterLogEventsInput) error {
+	catchUp := *params
+	catchUp.NextToken = nil
+	catchUp.StartTime = aws.Int64(c.lastTimestamp - liveTailCatchUp.Milliseconds())
+	catchUp.EndTime = aws.Int64(time.Now().UnixMilli())
+	if err := c.pollEvents(ctx, eventChan, &catchUp, false); err != nil {
+		return err
+	}
+
+	sampled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case streamEvent, ok := <-stream.Events():
+			if !ok {
+				return stream.Err()
+			}
+
+			update, ok := streamEvent.(*types.StartLiveTailResponseStreamMemberSessionUpdate)
+			if !ok {
+				continue
+			}
+
+			if update.Value.SessionMetadata != nil && update.Value.SessionMetadata.Sampled != sampled {
+				sampled = update.Value.SessionMetadata.Sampled
+				if sampled {
+					c.notify("live tail is receiving more than 500 events per second, events are being sampled")
+				}
+			}
+
+			for _, liveEvent := range update.Value.SessionResults {
+				event := types.FilteredLogEvent{
+					IngestionTime: liveEvent.IngestionTime,
+					LogStreamName: liveEvent.LogStreamName,
+					Message:       liveEvent.Message,
+					Timestamp:     liveEvent.Timestamp,
+				}
+				event.EventId = aws.String(liveTailEventKey(event))
+				if !c.emit(ctx, eventChan, event) {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// liveTailEventKey identifies an event by its content, as live tail events do
+// not carry the event ID returned by FilterLogEvents
+func liveTailEventKey(event types.FilteredLogEvent) string {
+	h := fnv.New64a()
+	h.Write([]byte(aws.ToString(event.Message)))
+	return fmt.Sprintf("%s/%d/%x", aws.ToString(event.LogStreamName), aws.ToInt64(event.Timestamp), h.Sum64())
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// MemoryInsightsSource is an in-memory InsightsSource. Every query it starts
+// goes through the same scripted polls, which makes it possible to exercise
+// queries offline. It is safe for concurrent use.
+type MemoryInsightsSource struct {
+	mu      sync.Mutex
+	polls   []MemoryQueryPoll
+	queries map[string]*memoryQuery
+	started []cloudwatchlogs.StartQueryInput
+}
+
+// MemoryQueryPoll is the answer to a GetQueryResults call
+type MemoryQueryPoll struct {
+	Status     types.QueryStatus
+	Statistics types.QueryStatistics
+	Results    [][]types.ResultField
+}
+
+type memoryQuery struct {
+	polls   int
+	stopped bool
+}
+
+// NewMemoryInsightsSource returns a MemoryInsightsSource answering the
+// GetQueryResults calls of each query with polls in order, the last one
+// repeated once they run out. Stopped queries keep the results of their last
+// poll with a Cancelled status.
+func NewMemoryInsightsSource(polls ...MemoryQueryPoll) *MemoryInsightsSource {
+	return &MemoryInsightsSource{
+		polls:   polls,
+		queries: map[string]*memoryQuery{},
+	}
+}
+
+// Started returns the input of every query started so far
+func (m *MemoryInsightsSource) Started() []cloudwatchlogs.StartQueryInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]cloudwatchlogs.StartQueryInput{}, m.started...)
+}
+
+// StartQuery implements InsightsSource
+func (m *MemoryInsightsSource) StartQuery(ctx context.Context, params *cloudwatchlogs.StartQueryInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StartQueryOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started = append(m.started, *params)
+	id := fmt.Sprintf("query-%d", len(m.started))
+	m.queries[id] = &memoryQuery{}
+	return &cloudwatchlogs.StartQueryOutput{QueryId: aws.String(id)}, nil
+}
+
+// GetQueryResults implements InsightsSource
+func (m *MemoryInsightsSource) GetQueryResults(ctx context.Context, params *cloudwatchlogs.GetQueryResultsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[aws.ToString(params.QueryId)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified query does not exist.")}
+	}
+	if len(m.polls) == 0 {
+		return &cloudwatchlogs.GetQueryResultsOutput{Status: types.QueryStatusComplete}, nil
+	}
+
+	if !q.stopped {
+		q.polls++
+	}
+	last := q.polls - 1
+	if last >= len(m.polls) {
+		last = len(m.polls) - 1
+	}
+
+	// Queries stopped before their first poll have no results
+	poll := MemoryQueryPoll{Status: types.QueryStatusScheduled}
+	if last >= 0 {
+		poll = m.polls[last]
+	}
+	if q.stopped {
+		poll.Status = types.QueryStatusCancelled
+	}
+
+	return &cloudwatchlogs.GetQueryResultsOutput{
+		Status:     poll.Status,
+		Statistics: &poll.Statistics,
+		Results:    poll.Results,
+	}, nil
+}
+
+// StopQuery implements InsightsSource
+func (m *MemoryInsightsSource) StopQuery(ctx context.Context, params *cloudwatchlogs.StopQueryInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StopQueryOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[aws.ToString(params.QueryId)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified query does not exist.")}
+	}
+	q.stopped = true
+	return &cloudwatchlogs.StopQueryOutput{Success: true}, nil
+}
@@ -0,0 +1,363 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// MemoryLogSource is an in-memory LogSource. It keeps groups, streams and
+// events in memory and answers describe/filter calls from them, which makes it
+// possible to exercise the reader offline. It is safe for concurrent use, so
+// events can be added while a reader is following it.
+type MemoryLogSource struct {
+	// PageSize is the maximum number of items returned per call, zero means
+	// no limit
+	PageSize int
+
+	mu     sync.Mutex
+	groups map[string]*memoryGroup
+	nextID int
+}
+
+type memoryGroup struct {
+	creationTime int64
+	streams      map[string]*memoryStream
+}
+
+type memoryStream struct {
+	creationTime int64
+	events       []types.FilteredLogEvent
+}
+
+// NewMemoryLogSource returns an empty MemoryLogSource
+func NewMemoryLogSource() *MemoryLogSource {
+	return &MemoryLogSource{
+		groups: map[string]*memoryGroup{},
+	}
+}
+
+// AddGroup creates a log group if it does not exist yet
+func (m *MemoryLogSource) AddGroup(name string, creationTime int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group(name, creationTime)
+}
+
+// AddStream creates a log stream (and its group) if it does not exist yet
+func (m *MemoryLogSource) AddStream(group string, stream string, creationTime int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stream(group, stream, creationTime)
+}
+
+// AddEvent appends a log event to a stream, creating the group and stream if
+// needed, and returns the generated event ID. The timestamp is in milliseconds
+// since epoch and is also used as ingestion time.
+func (m *MemoryLogSource) AddEvent(group string, stream string, timestamp int64, message string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stream(group, stream, timestamp)
+	if timestamp < s.creationTime {
+		s.creationTime = timestamp
+	}
+	m.nextID++
+	id := fmt.Sprintf("%020d%06d", timestamp, m.nextID)
+	s.events = append(s.events, types.FilteredLogEvent{
+		EventId:       aws.String(id),
+		IngestionTime: aws.Int64(timestamp),
+		LogStreamName: aws.String(stream),
+		Message:       aws.String(message),
+		Timestamp:     aws.Int64(timestamp),
+	})
+	sort.SliceStable(s.events, func(i, j int) bool { return *s.events[i].Timestamp < *s.events[j].Timestamp })
+
+	return id
+}
+
+func (m *MemoryLogSource) group(name string, creationTime int64) *memoryGroup {
+	g, ok := m.groups[name]
+	if !ok {
+		g = &memoryGroup{
+			creationTime: creationTime,
+			streams:      map[string]*memoryStream{},
+		}
+		m.groups[name] = g
+	}
+	return g
+}
+
+func (m *MemoryLogSource) stream(group string, name string, creationTime int64) *memoryStream {
+	g := m.group(group, creationTime)
+	s, ok := g.streams[name]
+	if !ok {
+		s = &memoryStream{creationTime: creationTime}
+		g.streams[name] = s
+	}
+	return s
+}
+
+// DescribeLogGroups implements cloudwatchlogs.DescribeLogGroupsAPIClient
+func (m *MemoryLogSource) DescribeLogGroups(ctx context.Context, params *cloudwatchlogs.DescribeLogGroupsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := aws.ToString(params.LogGroupNamePrefix)
+	groups := []types.LogGroup{}
+	for name, g := range m.groups {
+		if strings.HasPrefix(name, prefix) {
+			groups = append(groups, types.LogGroup{
+				LogGroupName: aws.String(name),
+				CreationTime: aws.Int64(g.creationTime),
+			})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return *groups[i].LogGroupName < *groups[j].LogGroupName })
+
+	page, next, err := paginate(groups, params.NextToken, m.pageSize(aws.ToInt32(params.Limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: page, NextToken: next}, nil
+}
+
+// DescribeLogStreams implements cloudwatchlogs.DescribeLogStreamsAPIClient
+func (m *MemoryLogSource) DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified log group does not exist.")}
+	}
+
+	prefix := aws.ToString(params.LogStreamNamePrefix)
+	streams := []types.LogStream{}
+	for name, s := range g.streams {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stream := types.LogStream{
+			LogStreamName: aws.String(name),
+			CreationTime:  aws.Int64(s.creationTime),
+		}
+		if n := len(s.events); n > 0 {
+			stream.FirstEventTimestamp = s.events[0].Timestamp
+			stream.LastEventTimestamp = s.events[n-1].Timestamp
+			stream.LastIngestionTime = s.events[n-1].IngestionTime
+		}
+		streams = append(streams, stream)
+	}
+
+	if params.OrderBy == types.OrderByLastEventTime {
+		sort.Slice(streams, func(i, j int) bool {
+			return aws.ToInt64(streams[i].LastEventTimestamp) < aws.ToInt64(streams[j].LastEventTimestamp)
+		})
+	} else {
+		sort.Slice(streams, func(i, j int) bool { return *streams[i].LogStreamName < *streams[j].LogStreamName })
+	}
+	if aws.ToBool(params.Descending) {
+		for i, j := 0, len(streams)-1; i < j; i, j = i+1, j-1 {
+			streams[i], streams[j] = streams[j], streams[i]
+		}
+	}
+
+	page, next, err := paginate(streams, params.NextToken, m.pageSize(aws.ToInt32(params.Limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: page, NextToken: next}, nil
+}
+
+// FilterLogEvents implements cloudwatchlogs.FilterLogEventsAPIClient. Filter
+// patterns are matched as plain substrings of the message.
+func (m *MemoryLogSource) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified log group does not exist.")}
+	}
+
+	names := params.LogStreamNames
+	if len(names) == 0 {
+		for name := range g.streams {
+			if strings.HasPrefix(name, aws.ToString(params.LogStreamNamePrefix)) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	pattern := aws.ToString(params.FilterPattern)
+	events := []types.FilteredLogEvent{}
+	for _, name := range names {
+		s, ok := g.streams[name]
+		if !ok {
+			continue
+		}
+		for _, e := range s.events {
+			if params.StartTime != nil && *e.Timestamp < *params.StartTime {
+				continue
+			}
+			if params.EndTime != nil && *e.Timestamp > *params.EndTime {
+				continue
+			}
+			if pattern != "" && !strings.Contains(*e.Message, strings.Trim(pattern, `"`)) {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if *events[i].Timestamp != *events[j].Timestamp {
+			return *events[i].Timestamp < *events[j].Timestamp
+		}
+		return *events[i].EventId < *events[j].EventId
+	})
+
+	page, next, err := paginate(events, params.NextToken, m.pageSize(aws.ToInt32(params.Limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudwatchlogs.FilterLogEventsOutput{Events: page, NextToken: next}, nil
+}
+
+// GetLogEvents implements cloudwatchlogs.GetLogEventsAPIClient. Tokens hold
+// the position in the stream to continue from.
+func (m *MemoryLogSource) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified log group does not exist.")}
+	}
+	s, ok := g.streams[aws.ToString(params.LogStreamName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified log stream does not exist.")}
+	}
+
+	// Positions of the events in the time range, the end time is exclusive
+	low, high := 0, len(s.events)
+	if params.StartTime != nil {
+		low = sort.Search(len(s.events), func(i int) bool { return *s.events[i].Timestamp >= *params.StartTime })
+	}
+	if params.EndTime != nil {
+		high = sort.Search(len(s.events), func(i int) bool { return *s.events[i].Timestamp >= *params.EndTime })
+	}
+	if high < low {
+		high = low
+	}
+
+	forward := aws.ToBool(params.StartFromHead)
+	position := -1
+	if params.NextToken != nil {
+		direction, offset, _ := strings.Cut(*params.NextToken, "/")
+		n, err := strconv.Atoi(offset)
+		if err != nil || (direction != "f" && direction != "b") {
+			return nil, &types.InvalidParameterException{Message: aws.String("The specified nextToken is invalid.")}
+		}
+		forward = direction == "f"
+		position = n
+	}
+
+	size := m.pageSize(aws.ToInt32(params.Limit))
+	if size == 0 {
+		size = len(s.events)
+	}
+	var from, to int
+	if forward {
+		from = low
+		if position >= 0 {
+			from = clamp(position, low, high)
+		}
+		to = clamp(from+size, low, high)
+	} else {
+		to = high
+		if position >= 0 {
+			to = clamp(position, low, high)
+		}
+		from = clamp(to-size, low, high)
+	}
+
+	events := make([]types.OutputLogEvent, 0, to-from)
+	for _, e := range s.events[from:to] {
+		events = append(events, types.OutputLogEvent{
+			IngestionTime: e.IngestionTime,
+			Message:       e.Message,
+			Timestamp:     e.Timestamp,
+		})
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events:            events,
+		NextForwardToken:  aws.String(fmt.Sprintf("f/%d", to)),
+		NextBackwardToken: aws.String(fmt.Sprintf("b/%d", from)),
+	}, nil
+}
+
+func clamp(n int, low int, high int) int {
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
+
+// pageSize returns the number of items to return for a call with limit
+func (m *MemoryLogSource) pageSize(limit int32) int {
+	size := m.PageSize
+	if limit > 0 && (size == 0 || int(limit) < size) {
+		size = int(limit)
+	}
+	return size
+}
+
+// paginate slices items starting at the offset encoded in token and returns
+// the token for the following page, if any
+func paginate[T any](items []T, token *string, size int) ([]T, *string, error) {
+	offset := 0
+	if token != nil {
+		var err error
+		offset, err = strconv.Atoi(*token)
+		if err != nil || offset < 0 || offset > len(items) {
+			return nil, nil, &types.InvalidParameterException{Message: aws.String("The specified nextToken is invalid.")}
+		}
+	}
+
+	if size == 0 || offset+size >= len(items) {
+		return items[offset:], nil, nil
+	}
+
+	return items[offset : offset+size], aws.String(strconv.Itoa(offset + size)), nil
+}
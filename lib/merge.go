@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMergeBufferSize is the default number of events held back to
+	// reorder merged streams
+	DefaultMergeBufferSize = 1000
+	// DefaultMergeDelay is the default time an event can be held back waiting
+	// for older events from other streams
+	DefaultMergeDelay = 2 * time.Second
+)
+
+// MergeEvents merges several event channels into one, ordered by
+// CreationTime. Events are held in a reorder buffer until every open input
+// has delivered at least one event, so the oldest one can be emitted safely.
+// To keep quiet inputs from stalling the output, the buffer is bounded to
+// bufferSize events and no event is held for longer than maxDelay. The
+// returned channel is closed once every input is closed, even when ctx is
+// done, so the errors of the readers can safely be read after it is.
+func MergeEvents(ctx context.Context, bufferSize int, maxDelay time.Duration, inputs ...<-chan Event) <-chan Event {
+	if len(inputs) == 1 {
+		return inputs[0]
+	}
+
+	out := make(chan Event)
+	go mergeEvents(ctx, out, bufferSize, maxDelay, inputs)
+	return out
+}
+
+type mergeItem struct {
+	event   Event
+	input   int
+	arrival time.Time
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	return h[i].event.CreationTime.Before(h[j].event.CreationTime)
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func mergeEvents(ctx context.Context, out chan<- Event, bufferSize int, maxDelay time.Duration, inputs []<-chan Event) {
+	var drained sync.WaitGroup
+	defer func() {
+		drained.Wait()
+		close(out)
+	}()
+
+	received := make(chan mergeItem)
+	closed := make(chan int)
+	for ix, input := range inputs {
+		drained.Add(1)
+		go func(ix int, input <-chan Event) {
+			defer drained.Done()
+			for event := range input {
+				select {
+				case received <- mergeItem{event: event, input: ix}:
+				case <-ctx.Done():
+					// Keep draining so the reader can finish and close
+				}
+			}
+			select {
+			case closed <- ix:
+			case <-ctx.Done():
+			}
+		}(ix, input)
+	}
+
+	buffer := &mergeHeap{}
+	pending := make([]int, len(inputs))
+	open := make([]bool, len(inputs))
+	for ix := range open {
+		open[ix] = true
+	}
+	remaining := len(inputs)
+
+	// ready reports whether the oldest buffered event can be emitted
+	ready := func() bool {
+		if buffer.Len() == 0 {
+			return false
+		}
+		if buffer.Len() > bufferSize {
+			return true
+		}
+		if maxDelay > 0 && time.Since((*buffer)[0].arrival) >= maxDelay {
+			return true
+		}
+		for ix := range inputs {
+			if open[ix] && pending[ix] == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	flush := func() bool {
+		for ready() {
+			item := heap.Pop(buffer).(mergeItem)
+			pending[item.input]--
+			select {
+			case out <- item.event:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		return true
+	}
+
+	tick := time.Second
+	if maxDelay > 0 && maxDelay < tick {
+		tick = maxDelay
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for remaining > 0 {
+		select {
+		case item := <-received:
+			item.arrival = time.Now()
+			heap.Push(buffer, item)
+			pending[item.input]++
+		case ix := <-closed:
+			open[ix] = false
+			remaining--
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if !flush() {
+			return
+		}
+	}
+
+	// Every input is closed, whatever is left is already in order
+	for buffer.Len() > 0 {
+		item := heap.Pop(buffer).(mergeItem)
+		select {
+		case out <- item.event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sendEvents returns a channel sending an event at each of the given seconds
+// after testStart, then closed
+func sendEvents(seconds ...int) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for _, n := range seconds {
+			events <- Event{ID: fmt.Sprint(n), CreationTime: testStart.Add(time.Duration(n) * time.Second)}
+		}
+	}()
+	return events
+}
+
+func collect(events <-chan Event) []string {
+	messages := []string{}
+	for event := range events {
+		messages = append(messages, event.ID)
+	}
+	return messages
+}
+
+func TestMergeEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		inputs     [][]int
+		want       []string
+	}{
+		{name: "single input", inputs: [][]int{{3, 1, 2}}, want: []string{"3", "1", "2"}},
+		{name: "interleaved", bufferSize: 10, inputs: [][]int{{1, 4, 5}, {2, 3, 6}, {}}, want: []string{"1", "2", "3", "4", "5", "6"}},
+		{name: "uneven", bufferSize: 10, inputs: [][]int{{1, 2, 3, 4, 5}, {0}}, want: []string{"0", "1", "2", "3", "4", "5"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := []<-chan Event{}
+			for _, seconds := range test.inputs {
+				inputs = append(inputs, sendEvents(seconds...))
+			}
+			got := collect(MergeEvents(context.Background(), test.bufferSize, time.Minute, inputs...))
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// A quiet input holds events back only until the buffer is full or they are
+// older than maxDelay
+func TestMergeEventsQuietInput(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		maxDelay   time.Duration
+	}{
+		{name: "bounded buffer", bufferSize: 1, maxDelay: time.Minute},
+		{name: "max delay", bufferSize: 10, maxDelay: 10 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quiet := make(chan Event)
+			defer close(quiet)
+
+			out := MergeEvents(context.Background(), test.bufferSize, test.maxDelay, sendEvents(1, 2), quiet)
+			select {
+			case event := <-out:
+				if event.ID != "1" {
+					t.Errorf("got %s, want 1", event.ID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("events held back by a quiet input")
+			}
+		})
+	}
+}
+
+// Once ctx is done the output is only closed after the inputs are, so the
+// readers are done writing their errors
+func TestMergeEventsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan Event)
+	second := make(chan Event)
+	out := MergeEvents(ctx, 10, time.Minute, first, second)
+
+	first <- Event{ID: "held back"}
+	cancel()
+
+	inputsClosed := false
+	go func() {
+		// Inputs are still drained after ctx is done
+		second <- Event{ID: "drained"}
+		time.Sleep(20 * time.Millisecond)
+		inputsClosed = true
+		close(first)
+		close(second)
+	}()
+
+	for range out {
+	}
+	if !inputsClosed {
+		t.Error("output closed before the inputs")
+	}
+}
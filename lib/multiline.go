@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMultilineMaxLines is the default number of lines joined into a
+	// single event
+	DefaultMultilineMaxLines = 500
+	// DefaultMultilineTimeout is how long a multi-line event waits for more
+	// lines by default
+	DefaultMultilineTimeout = 2 * time.Second
+)
+
+var (
+	pythonTracebackStart = "Traceback (most recent call last):"
+	// continuationRegexp matches unindented lines that still belong to a Java
+	// stack trace
+	continuationRegexp = regexp.MustCompile(`^(Caused by: |Suppressed: |\.\.\. \d+ (more|common frames omitted))`)
+)
+
+// MultilineOptions configures how lines are joined into multi-line events
+type MultilineOptions struct {
+	// Start matches the first line of an event, any other line is joined to
+	// the previous event of its stream. When nil, indented lines (and the
+	// usual Java and Python stack trace lines) are joined instead.
+	Start *regexp.Regexp
+	// MaxLines is the maximum number of lines of an event
+	MaxLines int
+	// Timeout is how long an event waits for more lines before it is sent
+	Timeout time.Duration
+}
+
+// pendingEvent is an event waiting for continuation lines
+type pendingEvent struct {
+	event     Event
+	lines     []string
+	received  time.Time
+	traceback bool
+	indented  bool
+}
+
+// AggregateMultiline joins events holding continuation lines, e.g. each line
+// of a stack trace, into the previous event of the same stream. The joined
+// event keeps the time, ID and parsed fields of its first line, with the
+// lines joined in its message. Lines are told apart on the original message
+// of the events, so parsed ones (e.g. logfmt or Lambda lines) are joined too.
+func AggregateMultiline(ctx context.Context, in <-chan Event, opts MultilineOptions) <-chan Event {
+	if opts.MaxLines < 1 {
+		opts.MaxLines = DefaultMultilineMaxLines
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+
+		pending := map[string]*pendingEvent{}
+		send := func(event Event) bool {
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		flush := func(key string) bool {
+			p, ok := pending[key]
+			if !ok {
+				return true
+			}
+			delete(pending, key)
+			return send(p.joined())
+		}
+
+		var tick <-chan time.Time
+		if opts.Timeout > 0 {
+			interval := opts.Timeout / 2
+			if interval > time.Second {
+				interval = time.Second
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					// Send what is left in timestamp order
+					keys := make([]string, 0, len(pending))
+					for key := range pending {
+						keys = append(keys, key)
+					}
+					sort.Slice(keys, func(i, j int) bool {
+						return pending[keys[i]].event.CreationTime.Before(pending[keys[j]].event.CreationTime)
+					})
+					for _, key := range keys {
+						if !flush(key) {
+							return
+						}
+					}
+					return
+				}
+
+				key := strings.Join([]string{event.Account, event.Region, event.Group, event.Stream}, "\x00")
+				line := rawLine(event)
+				if p, ok := pending[key]; ok && p.continues(line, opts.Start) {
+					p.add(line)
+					if len(p.lines) >= opts.MaxLines || p.complete() {
+						if !flush(key) {
+							return
+						}
+					}
+					continue
+				}
+
+				if !flush(key) {
+					return
+				}
+				p := &pendingEvent{event: event, received: time.Now()}
+				p.add(line)
+				p.traceback = strings.HasPrefix(line, pythonTracebackStart)
+				pending[key] = p
+			case now := <-tick:
+				for key, p := range pending {
+					if now.Sub(p.received) >= opts.Timeout {
+						if !flush(key) {
+							return
+						}
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// rawLine returns the original message of an event, or its message field
+// when it has none
+func rawLine(event Event) string {
+	line := event.Raw
+	if line == "" {
+		line, _ = event.Event["message"].(string)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func (p *pendingEvent) add(line string) {
+	p.lines = append(p.lines, line)
+	p.indented = isIndented(line)
+}
+
+// continues reports whether line continues the pending event
+func (p *pendingEvent) continues(line string, start *regexp.Regexp) bool {
+	if start != nil {
+		return !start.MatchString(line)
+	}
+	if line == "" {
+		return false
+	}
+	if isIndented(line) || continuationRegexp.MatchString(line) {
+		return true
+	}
+	// The exception closing a Python traceback is not indented
+	return p.traceback && p.indented
+}
+
+// complete reports whether nothing more can be joined, i.e. a Python
+// traceback got its closing exception line
+func (p *pendingEvent) complete() bool {
+	return p.traceback && len(p.lines) > 1 && !p.indented
+}
+
+func (p *pendingEvent) joined() Event {
+	if len(p.lines) == 1 {
+		return p.event
+	}
+
+	event := p.event
+	head, ok := event.Event["message"].(string)
+	if !ok {
+		head = p.lines[0]
+	}
+	fields := make(map[string]interface{}, len(event.Event)+1)
+	for key, value := range event.Event {
+		fields[key] = value
+	}
+	fields["message"] = strings.Join(append([]string{strings.TrimRight(head, "\r\n")}, p.lines[1:]...), "\n")
+
+	event.Event = fields
+	event.Raw = strings.Join(p.lines, "\n")
+	return event
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
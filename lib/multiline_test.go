@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// Lines are joined whatever the parser made of them, keeping the fields of
+// the first line
+func TestAggregateMultilineParsed(t *testing.T) {
+	tests := []struct {
+		parser string
+		lines  []string
+		field  string
+		value  interface{}
+	}{
+		{
+			parser: "auto",
+			lines:  []string{"level=error msg=\"request failed\"", "\tat com.example.Main.run(Main.java:10)", "Caused by: java.io.IOException"},
+			field:  "level",
+			value:  "error",
+		},
+		{
+			parser: "lambda",
+			lines:  []string{"START RequestId: 8f5f7c3a-1111-2222-3333-444455556666 Version: $LATEST", "  continued"},
+			field:  "type",
+			value:  "start",
+		},
+		{
+			parser: "json",
+			lines:  []string{"Traceback (most recent call last):", `  File "main.py", line 1, in <module>`, "ValueError: boom"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.parser, func(t *testing.T) {
+			parser, err := NewParser(test.parser)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			in := make(chan Event, len(test.lines)+1)
+			for i, line := range append(test.lines, "next event") {
+				in <- NewEventWithParser(types.FilteredLogEvent{
+					EventId:       aws.String(fmt.Sprint(i)),
+					Timestamp:     aws.Int64(testTime(i)),
+					IngestionTime: aws.Int64(testTime(i)),
+					Message:       aws.String(line),
+					LogStreamName: aws.String("stream"),
+				}, "group", parser)
+			}
+			close(in)
+
+			events := []Event{}
+			for event := range AggregateMultiline(context.Background(), in, MultilineOptions{Timeout: time.Minute}) {
+				events = append(events, event)
+			}
+			if len(events) != 2 {
+				t.Fatalf("got %d events, want 2: %v", len(events), events)
+			}
+
+			joined := events[0]
+			want := ""
+			for i, line := range test.lines {
+				if i > 0 {
+					want += "\n"
+				}
+				want += line
+			}
+			if joined.Raw != want || joined.Message() != want {
+				t.Errorf("got raw %q and message %q, want %q", joined.Raw, joined.Message(), want)
+			}
+			if test.field != "" && joined.Event[test.field] != test.value {
+				t.Errorf("got %s %v, want %v", test.field, joined.Event[test.field], test.value)
+			}
+			if joined.ID != "0" || !joined.CreationTime.Equal(time.UnixMilli(testTime(0))) {
+				t.Errorf("joined event does not keep the ID and time of its first line")
+			}
+		})
+	}
+}
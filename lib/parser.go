@@ -0,0 +1,309 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parser turns the message of a log event into structured fields
+type Parser interface {
+	// Parse returns the fields of message, or false if message is not in the
+	// format of the parser
+	Parse(message string) (map[string]interface{}, bool)
+}
+
+// ParserFunc adapts a function to the Parser interface
+type ParserFunc func(message string) (map[string]interface{}, bool)
+
+// Parse implements Parser
+func (f ParserFunc) Parse(message string) (map[string]interface{}, bool) {
+	return f(message)
+}
+
+// DefaultParser is the parser used when none is selected. Detecting the
+// format of each message is opt-in with the auto parser.
+const DefaultParser = "json"
+
+var parsers = map[string]Parser{
+	"json":     ParserFunc(parseJSON),
+	"logfmt":   ParserFunc(parseLogfmt),
+	"clf":      ParserFunc(parseCommonLog),
+	"combined": ParserFunc(parseCombinedLog),
+	"syslog":   ParserFunc(parseSyslog),
+	"auto":     ParserFunc(parseAuto),
+}
+
+// RegisterParser makes a message format available through NewParser
+func RegisterParser(name string, parser Parser) {
+	parsers[name] = parser
+}
+
+// Parsers returns the names of the registered message formats
+func Parsers() []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewParser returns the parser of a registered message format, or a regular
+// expression parser for names like regex:<expression>
+func NewParser(name string) (Parser, error) {
+	if expr, ok := strings.CutPrefix(name, "regex:"); ok {
+		return NewRegexParser(expr)
+	}
+
+	parser, ok := parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown parser '%s', expected regex:<expression> or one of: %s", name, strings.Join(Parsers(), ", "))
+	}
+	return parser, nil
+}
+
+// parseMessage returns the fields of message, falling back to storing it as
+// is under message
+func parseMessage(parser Parser, message string) map[string]interface{} {
+	if parser == nil {
+		parser = ParserFunc(parseJSON)
+	}
+	if fields, ok := parser.Parse(message); ok {
+		return fields
+	}
+	return map[string]interface{}{"message": message}
+}
+
+// withMessage keeps the original line under message for formats that do not
+// have one, so templates using .Event.message keep working
+func withMessage(fields map[string]interface{}, message string) map[string]interface{} {
+	if _, ok := fields["message"]; !ok {
+		fields["message"] = message
+	}
+	return fields
+}
+
+func parseJSON(message string) (map[string]interface{}, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// parseAuto detects the format of each message, trying JSON, syslog, access
+// logs and finally logfmt. Only messages made of key=value pairs alone are
+// taken as logfmt.
+func parseAuto(message string) (map[string]interface{}, bool) {
+	trimmed := strings.TrimSpace(message)
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJSON(message)
+	}
+	if strings.HasPrefix(trimmed, "<") {
+		if fields, ok := parseSyslog(message); ok {
+			return fields, true
+		}
+	}
+	if fields, ok := parseCombinedLog(message); ok {
+		return fields, true
+	}
+	if fields, ok := parseCommonLog(message); ok {
+		return fields, true
+	}
+
+	pairs, ok := splitLogfmt(message)
+	if !ok || len(pairs) < 2 {
+		return nil, false
+	}
+	for _, pair := range pairs {
+		if !pair.hasValue {
+			return nil, false
+		}
+	}
+	return withMessage(logfmtFields(pairs), message), true
+}
+
+type logfmtPair struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func parseLogfmt(message string) (map[string]interface{}, bool) {
+	pairs, ok := splitLogfmt(message)
+	if !ok || len(pairs) == 0 {
+		return nil, false
+	}
+	return withMessage(logfmtFields(pairs), message), true
+}
+
+func logfmtFields(pairs []logfmtPair) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, pair := range pairs {
+		if pair.hasValue {
+			fields[pair.key] = pair.value
+		} else {
+			fields[pair.key] = true
+		}
+	}
+	return fields
+}
+
+// splitLogfmt splits a logfmt line into its pairs. Keys without a value
+// (e.g. debug in `debug level=info`) are returned with hasValue unset.
+func splitLogfmt(line string) ([]logfmtPair, bool) {
+	pairs := []logfmtPair{}
+	i := 0
+	for i < len(line) {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, false
+		}
+		if i >= len(line) || line[i] != '=' {
+			if i < len(line) && line[i] == '"' {
+				return nil, false
+			}
+			pairs = append(pairs, logfmtPair{key: key})
+			continue
+		}
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			pairs = append(pairs, logfmtPair{key: key, value: value, hasValue: true})
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		pairs = append(pairs, logfmtPair{key: key, value: line[start:i], hasValue: true})
+	}
+
+	return pairs, true
+}
+
+var (
+	commonLogRegexp   = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "([^"\\]*(?:\\.[^"\\]*)*)" (\d{3}) (\d+|-)`)
+	combinedLogRegexp = regexp.MustCompile(commonLogRegexp.String() + ` "([^"\\]*(?:\\.[^"\\]*)*)" "([^"\\]*(?:\\.[^"\\]*)*)"`)
+)
+
+// parseCommonLog parses Apache and Nginx common log format lines
+func parseCommonLog(message string) (map[string]interface{}, bool) {
+	match := commonLogRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return nil, false
+	}
+	return withMessage(accessLogFields(match), message), true
+}
+
+// parseCombinedLog parses Apache and Nginx combined log format lines, the
+// default format of Nginx access logs
+func parseCombinedLog(message string) (map[string]interface{}, bool) {
+	match := combinedLogRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return nil, false
+	}
+	fields := accessLogFields(match)
+	setUnlessDash(fields, "referer", match[8])
+	setUnlessDash(fields, "user_agent", match[9])
+	return withMessage(fields, message), true
+}
+
+func accessLogFields(match []string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	setUnlessDash(fields, "remote_addr", match[1])
+	setUnlessDash(fields, "ident", match[2])
+	setUnlessDash(fields, "user", match[3])
+	fields["time"] = match[4]
+	fields["request"] = match[5]
+	if parts := strings.Fields(match[5]); len(parts) == 3 {
+		fields["method"] = parts[0]
+		fields["path"] = parts[1]
+		fields["protocol"] = parts[2]
+	}
+	// Numbers are float64, the same as JSON fields
+	status, _ := strconv.ParseFloat(match[6], 64)
+	fields["status"] = status
+	bytes, _ := strconv.ParseFloat(match[7], 64)
+	fields["bytes"] = bytes
+	return fields
+}
+
+func setUnlessDash(fields map[string]interface{}, key string, value string) {
+	if value != "-" && value != "" {
+		fields[key] = value
+	}
+}
+
+// RegexParser parses messages with a regular expression, storing the value
+// of each named group as a field
+type RegexParser struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+// NewRegexParser returns a parser for expr, which must have at least one named
+// group (e.g. `(?P<level>\w+) (?P<msg>.*)`)
+func NewRegexParser(expr string) (*RegexParser, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parser expression: %w", err)
+	}
+
+	named := false
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			named = true
+		}
+	}
+	if !named {
+		return nil, fmt.Errorf("parser expression '%s' has no named group, e.g. (?P<level>\\w+)", expr)
+	}
+
+	return &RegexParser{re: re, names: re.SubexpNames()}, nil
+}
+
+// Parse implements Parser
+func (p *RegexParser) Parse(message string) (map[string]interface{}, bool) {
+	match := p.re.FindStringSubmatch(message)
+	if match == nil {
+		return nil, false
+	}
+
+	fields := map[string]interface{}{}
+	for i, name := range p.names {
+		if name != "" && i < len(match) {
+			fields[name] = match[i]
+		}
+	}
+	return withMessage(fields, message), true
+}
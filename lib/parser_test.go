@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsers(t *testing.T) {
+	const (
+		logfmtLine   = `level=info msg="user logged in" user=42 debug`
+		commonLine   = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`
+		combinedLine = commonLine + ` "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+		syslogLine   = `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`
+		regexParser  = `regex:^(?P<level>[A-Z]+) \[(?P<thread>[^\]]+)\] (?P<msg>.*)`
+	)
+
+	commonFields := map[string]interface{}{
+		"remote_addr": "127.0.0.1",
+		"user":        "frank",
+		"time":        "10/Oct/2000:13:55:36 -0700",
+		"request":     "GET /apache_pb.gif HTTP/1.0",
+		"method":      "GET",
+		"path":        "/apache_pb.gif",
+		"protocol":    "HTTP/1.0",
+		"status":      float64(200),
+		"bytes":       float64(2326),
+		"message":     commonLine,
+	}
+	combinedFields := map[string]interface{}{
+		"referer":    "http://www.example.com/start.html",
+		"user_agent": "Mozilla/4.08 [en] (Win98; I ;Nav)",
+		"message":    combinedLine,
+	}
+	for key, value := range commonFields {
+		if key != "message" {
+			combinedFields[key] = value
+		}
+	}
+	syslogFields := map[string]interface{}{
+		"priority":  float64(165),
+		"facility":  float64(20),
+		"severity":  "notice",
+		"timestamp": "2003-10-11T22:14:15.003Z",
+		"hostname":  "mymachine.example.com",
+		"app_name":  "evntslog",
+		"msgid":     "ID47",
+		"structured_data": map[string]interface{}{
+			"exampleSDID@32473": map[string]interface{}{"iut": "3", "eventSource": "Application"},
+		},
+		"message": "An application event",
+	}
+
+	tests := []struct {
+		parser  string
+		message string
+		want    map[string]interface{}
+	}{
+		{"json", `{"level":"warn","count":3}`, map[string]interface{}{"level": "warn", "count": float64(3)}},
+		{"json", `{"level":`, nil},
+		{"json", logfmtLine, nil},
+
+		{"logfmt", logfmtLine, map[string]interface{}{
+			"level":   "info",
+			"msg":     "user logged in",
+			"user":    "42",
+			"debug":   true,
+			"message": logfmtLine,
+		}},
+		{"logfmt", `msg="escaped \"quote\""`, map[string]interface{}{"msg": `escaped "quote"`, "message": `msg="escaped \"quote\""`}},
+		{"logfmt", `level=info msg="unterminated`, nil},
+		{"logfmt", `=value`, nil},
+		{"logfmt", ``, nil},
+
+		{"clf", commonLine, commonFields},
+		{"clf", `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] GET / 200 2326`, nil},
+		{"combined", combinedLine, combinedFields},
+		{"combined", commonLine, nil},
+
+		{"syslog", syslogLine, syslogFields},
+		{"syslog", `<34>1 2003-10-11T22:14:15.003Z host su - - - 'su root' failed`, map[string]interface{}{
+			"priority":  float64(34),
+			"facility":  float64(4),
+			"severity":  "crit",
+			"timestamp": "2003-10-11T22:14:15.003Z",
+			"hostname":  "host",
+			"app_name":  "su",
+			"message":   "'su root' failed",
+		}},
+		{"syslog", `<200>1 2003-10-11T22:14:15.003Z host app - - - too high`, nil},
+		{"syslog", `<34>1 2003-10-11T22:14:15.003Z host`, nil},
+		{"syslog", `<34>1 2003-10-11T22:14:15.003Z host app - - [unterminated a="b"`, nil},
+		{"syslog", `Oct 11 22:14:15 host su: 'su root' failed`, nil},
+
+		{regexParser, `ERROR [main] connection refused`, map[string]interface{}{
+			"level":   "ERROR",
+			"thread":  "main",
+			"msg":     "connection refused",
+			"message": `ERROR [main] connection refused`,
+		}},
+		{regexParser, `error: connection refused`, nil},
+
+		{"auto", `{"level":"warn"}`, map[string]interface{}{"level": "warn"}},
+		{"auto", `{"level":`, nil},
+		{"auto", syslogLine, syslogFields},
+		{"auto", combinedLine, combinedFields},
+		{"auto", commonLine, commonFields},
+		{"auto", `level=info status=200`, map[string]interface{}{"level": "info", "status": "200", "message": `level=info status=200`}},
+		// Only lines made of key=value pairs alone are taken as logfmt
+		{"auto", logfmtLine, nil},
+		{"auto", `retries=3`, nil},
+		{"auto", `Starting server on :8080`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.parser+"/"+test.message, func(t *testing.T) {
+			parser, err := NewParser(test.parser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := parser.Parse(test.message)
+			if ok != (test.want != nil) {
+				t.Fatalf("got ok %t for %v", ok, got)
+			}
+			if ok && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewParserErrors(t *testing.T) {
+	for _, name := range []string{"xml", "regex:(?P<level>", "regex:[A-Z]+ .*", "regex:"} {
+		if _, err := NewParser(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Messages not in the format of the parser are kept as they are, without a
+// parser they are parsed as JSON
+func TestParseMessage(t *testing.T) {
+	want := map[string]interface{}{"message": "plain text"}
+	for _, parser := range []Parser{nil, ParserFunc(parseAuto), ParserFunc(parseSyslog)} {
+		if got := parseMessage(parser, "plain text"); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got := parseMessage(nil, `{"level":"warn"}`); !reflect.DeepEqual(got, map[string]interface{}{"level": "warn"}) {
+		t.Errorf("got %v, want the JSON fields", got)
+	}
+}
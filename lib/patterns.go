@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// PatternWildcard replaces the variable tokens of a pattern
+	PatternWildcard = "<*>"
+
+	// DefaultPatternDepth is the default depth of the pattern tree, the
+	// number of leading tokens used to pick candidate patterns is three less
+	DefaultPatternDepth = 4
+	// DefaultPatternSimilarity is the default share of tokens a message must
+	// have in common with a pattern to be part of it
+	DefaultPatternSimilarity = 0.4
+	// DefaultPatternMaxChildren is the default maximum number of children of
+	// a pattern tree node, further tokens share a wildcard child
+	DefaultPatternMaxChildren = 100
+)
+
+// variableRegexp matches values that are masked before clustering: UUIDs,
+// IP addresses, hex values and numbers with an optional unit
+var variableRegexp = regexp.MustCompile(`(?i)\b(?:` +
+	`[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12}` +
+	`|\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?` +
+	`|0x[0-9a-f]+` +
+	`|[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*` +
+	`|\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h|%|b|kb|mb|gb|kib|mib|gib)?` +
+	`)\b`)
+
+// PatternOptions configures a PatternMiner
+type PatternOptions struct {
+	// Depth is the depth of the pattern tree
+	Depth int
+	// Similarity is the share of tokens (0-1) a message must have in common
+	// with a pattern to be part of it
+	Similarity float64
+	// MaxChildren is the maximum number of children of a tree node
+	MaxChildren int
+}
+
+// Pattern is a message template shared by similar events, with the
+// variable tokens replaced by PatternWildcard
+type Pattern struct {
+	ID        int
+	Tokens    []string
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Example is the first message of the pattern
+	Example string
+}
+
+// Template returns the pattern as text
+func (p *Pattern) Template() string {
+	return strings.Join(p.Tokens, " ")
+}
+
+// patternNode is a node of the pattern tree, leaves hold the patterns
+type patternNode struct {
+	children map[string]*patternNode
+	patterns []*Pattern
+}
+
+// PatternMiner groups messages into patterns with the Drain algorithm. A
+// fixed depth tree routes messages by their number of tokens and leading
+// tokens to a few candidate patterns, and the most similar one is picked or
+// a new pattern is started.
+type PatternMiner struct {
+	opts     PatternOptions
+	lengths  map[int]*patternNode
+	patterns []*Pattern
+}
+
+// NewPatternMiner returns an empty PatternMiner, zero options get their
+// default values
+func NewPatternMiner(opts PatternOptions) *PatternMiner {
+	if opts.Depth < 3 {
+		opts.Depth = DefaultPatternDepth
+	}
+	if opts.Similarity <= 0 {
+		opts.Similarity = DefaultPatternSimilarity
+	}
+	if opts.MaxChildren < 1 {
+		opts.MaxChildren = DefaultPatternMaxChildren
+	}
+
+	return &PatternMiner{opts: opts, lengths: map[int]*patternNode{}}
+}
+
+// Add adds the message of an event to its pattern and returns it, reporting
+// whether the pattern is new
+func (m *PatternMiner) Add(event Event) (*Pattern, bool) {
+	message, _, _ := strings.Cut(strings.TrimSpace(event.Message()), "\n")
+	tokens := patternTokens(message)
+	leaf := m.leaf(tokens)
+
+	var best *Pattern
+	bestSimilarity, bestWildcards := -1.0, -1
+	for _, p := range leaf.patterns {
+		similarity, wildcards := patternSimilarity(p.Tokens, tokens)
+		if similarity > bestSimilarity || (similarity == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = p, similarity, wildcards
+		}
+	}
+
+	if best != nil && bestSimilarity >= m.opts.Similarity {
+		for i, token := range tokens {
+			if best.Tokens[i] != token {
+				best.Tokens[i] = PatternWildcard
+			}
+		}
+		best.Count++
+		if event.CreationTime.Before(best.FirstSeen) {
+			best.FirstSeen = event.CreationTime
+		}
+		if event.CreationTime.After(best.LastSeen) {
+			best.LastSeen = event.CreationTime
+		}
+		return best, false
+	}
+
+	p := &Pattern{
+		ID:        len(m.patterns) + 1,
+		Tokens:    tokens,
+		Count:     1,
+		FirstSeen: event.CreationTime,
+		LastSeen:  event.CreationTime,
+		Example:   message,
+	}
+	leaf.patterns = append(leaf.patterns, p)
+	m.patterns = append(m.patterns, p)
+	return p, true
+}
+
+// leaf returns the tree node holding the candidate patterns of tokens,
+// creating the path to it as needed
+func (m *PatternMiner) leaf(tokens []string) *patternNode {
+	node, ok := m.lengths[len(tokens)]
+	if !ok {
+		node = &patternNode{children: map[string]*patternNode{}}
+		m.lengths[len(tokens)] = node
+	}
+
+	for i := 0; i < m.opts.Depth-3 && i < len(tokens); i++ {
+		token := tokens[i]
+		if strings.ContainsAny(token, "0123456789") {
+			token = PatternWildcard
+		}
+
+		child, ok := node.children[token]
+		if !ok {
+			if len(node.children) >= m.opts.MaxChildren {
+				token = PatternWildcard
+				child = node.children[token]
+			}
+			if child == nil {
+				child = &patternNode{children: map[string]*patternNode{}}
+				node.children[token] = child
+			}
+		}
+		node = child
+	}
+
+	return node
+}
+
+// Patterns returns the patterns found so far, the most frequent first
+func (m *PatternMiner) Patterns() []*Pattern {
+	sorted := append([]*Pattern(nil), m.patterns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Count > sorted[j].Count
+	})
+	return sorted
+}
+
+// patternTokens masks the variable values of message and splits it in tokens
+func patternTokens(message string) []string {
+	return strings.Fields(variableRegexp.ReplaceAllString(message, PatternWildcard))
+}
+
+// patternSimilarity returns the share of tokens equal in a pattern and a
+// message of the same length, and the number of wildcards of the pattern
+func patternSimilarity(pattern []string, tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+
+	equal, wildcards := 0, 0
+	for i, token := range pattern {
+		if token == tokens[i] {
+			equal++
+		}
+		if token == PatternWildcard {
+			wildcards++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), wildcards
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatternTokens(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"request took 120ms", "request took <*>"},
+		{"from 10.0.0.1:8080 to 192.168.1.1", "from <*> to <*>"},
+		{"user 4f9c2d1e-8a7b-4c3d-9e8f-1a2b3c4d5e6f logged in", "user <*> logged in"},
+		{"pointer 0x7ffe and hash 4deadbeef", "pointer <*> and hash <*>"},
+		{"took 1.5s after 3 retries", "took <*> after <*> retries"},
+		{"plain words stay", "plain words stay"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(patternTokens(test.message), " "); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.message, got, test.want)
+		}
+	}
+}
+
+func TestPatternMiner(t *testing.T) {
+	miner := NewPatternMiner(PatternOptions{})
+	add := func(n int, message string) (*Pattern, bool) {
+		return miner.Add(Event{
+			Event:        map[string]interface{}{"message": message},
+			CreationTime: testStart.Add(time.Duration(n) * time.Second),
+		})
+	}
+
+	connect, created := add(3, "connected to db as admin")
+	if !created || connect.Template() != "connected to db as admin" {
+		t.Fatalf("got %q (new %v), want a new pattern", connect.Template(), created)
+	}
+
+	// Differing tokens become wildcards
+	if p, created := add(1, "connected to db as reader"); created || p != connect {
+		t.Fatalf("similar message started pattern %q", p.Template())
+	}
+	if p, _ := add(5, "connected to cache as reader"); p != connect {
+		t.Fatalf("similar message started pattern %q", p.Template())
+	}
+	if want := "connected to <*> as <*>"; connect.Template() != want {
+		t.Errorf("got template %q, want %q", connect.Template(), want)
+	}
+	if connect.Count != 3 || connect.Example != "connected to db as admin" {
+		t.Errorf("got count %d and example %q", connect.Count, connect.Example)
+	}
+	if !connect.FirstSeen.Equal(testStart.Add(time.Second)) || !connect.LastSeen.Equal(testStart.Add(5*time.Second)) {
+		t.Errorf("got first seen %s and last seen %s", connect.FirstSeen, connect.LastSeen)
+	}
+
+	// Messages of another length, or too different, get their own pattern
+	if p, created := add(6, "connected to db"); !created || p == connect {
+		t.Error("message of another length joined a pattern")
+	}
+	if _, created := add(7, "connected via tls with no errors"); !created {
+		t.Error("message of another length joined a pattern")
+	}
+	if _, created := add(8, "connected mostly fine but still slow"); !created {
+		t.Error("dissimilar message joined a pattern")
+	}
+
+	// Only the first line of a message counts
+	if p, _ := add(9, "connected to queue as writer\n  at line 2"); p != connect {
+		t.Errorf("multi-line message started pattern %q", p.Template())
+	}
+
+	patterns := miner.Patterns()
+	if len(patterns) != 4 || patterns[0] != connect {
+		t.Errorf("got %d patterns, the most frequent first, want 4 with %q first", len(patterns), connect.Template())
+	}
+}
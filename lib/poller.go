@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// MinPollInterval is the shortest wait between polls while following and
+	// events are flowing
+	MinPollInterval = 100 * time.Millisecond
+	// MaxPollInterval is the longest wait between polls while following a
+	// quiet group
+	MaxPollInterval = 5 * time.Second
+	// MaxErrorBackoff is the longest wait before retrying after an error while
+	// following
+	MaxErrorBackoff = 1 * time.Minute
+	// LagThreshold is how far behind the newest ingested event the tail can
+	// fall before a notice is emitted
+	LagThreshold = 30 * time.Second
+	// FollowOverlap is how far before the newest event each follow poll
+	// starts, so events ingested late are still read
+	FollowOverlap = 30 * time.Second
+)
+
+const (
+	errorBackoffBase      = 500 * time.Millisecond
+	throttlingBackoffBase = 2 * time.Second
+	lagNoticeInterval     = 30 * time.Second
+)
+
+// poller keeps track of the follow loop state to adapt the polling interval:
+// it grows while polls come back empty, shrinks while events are flowing and
+// backs off exponentially, with jitter, on errors.
+type poller struct {
+	interval      time.Duration
+	failures      int
+	rounds        int
+	lastLagNotice time.Time
+}
+
+func newPoller() *poller {
+	return &poller{interval: MinPollInterval}
+}
+
+// success records a successful poll that produced n new events and returns
+// how long to wait before polling again
+func (p *poller) success(n int) time.Duration {
+	p.failures = 0
+	p.rounds++
+
+	if n > 0 {
+		p.interval /= 2
+	} else {
+		p.interval *= 2
+	}
+
+	if p.interval < MinPollInterval {
+		p.interval = MinPollInterval
+	}
+	if p.interval > MaxPollInterval {
+		p.interval = MaxPollInterval
+	}
+
+	return p.interval
+}
+
+// failure records a failed poll and returns how long to wait before retrying
+func (p *poller) failure(err error) time.Duration {
+	p.failures++
+
+	base := errorBackoffBase
+	if isThrottlingError(err) {
+		base = throttlingBackoffBase
+	}
+
+	backoff := MaxErrorBackoff
+	if p.failures < 16 {
+		if b := base << (p.failures - 1); b < MaxErrorBackoff {
+			backoff = b
+		}
+	}
+
+	// Equal jitter, wait between half and the full backoff
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// lagging reports whether a lag notice should be emitted after a poll that
+// produced n new events, given the newest ingestion time seen. No notice is
+// given until the initial fetch is done and at most one per lagNoticeInterval.
+func (p *poller) lagging(n int, lastIngestion time.Time) bool {
+	if p.rounds < 2 || n == 0 || lastIngestion.IsZero() {
+		return false
+	}
+	if time.Since(lastIngestion) < LagThreshold || time.Since(p.lastLagNotice) < lagNoticeInterval {
+		return false
+	}
+
+	p.lastLagNotice = time.Now()
+	return true
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isThrottlingError(err error) bool {
+	var throttling *types.ThrottlingException
+	if errors.As(err, &throttling) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ThrottlingException"
+}
+
+// isTransientError reports whether an error is worth retrying while
+// following. Client errors, like a missing group or denied access, are not.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isThrottlingError(err) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() != smithy.FaultClient
+	}
+
+	// Network errors and the like
+	return true
+}
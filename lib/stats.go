@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStatsBuckets is the number of histogram buckets aimed for when no
+	// bucket size is given
+	DefaultStatsBuckets = 60
+	// maxStatsBuckets is the maximum number of histogram buckets of a window
+	maxStatsBuckets = 10000
+	// maxStatsMessages is the number of distinct messages counted for the top
+	// messages, later ones are not ranked to bound memory use
+	maxStatsMessages = 100000
+	// maxStatsMessageLength is the length messages are cut to before counting
+	maxStatsMessageLength = 200
+)
+
+// statsBucketSizes are the bucket sizes picked from when none is given
+var statsBucketSizes = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+	7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// StatsOptions configures Stats
+type StatsOptions struct {
+	Start time.Time
+	End   time.Time
+	// Bucket is the size of the histogram buckets, when zero one giving about
+	// DefaultStatsBuckets buckets is picked
+	Bucket time.Duration
+	// GroupBy is the dotted path of a parsed field to count events by (e.g.
+	// level)
+	GroupBy string
+}
+
+// StatsCount holds the events counted for a stream or field value
+type StatsCount struct {
+	Group  string
+	Name   string
+	Events int
+	Bytes  int64
+	// Buckets holds the number of events in each histogram bucket
+	Buckets []int
+}
+
+// MessageCount is the number of times a message was seen
+type MessageCount struct {
+	Message string
+	Events  int
+}
+
+// Stats aggregates the volume of the events of a time window
+type Stats struct {
+	Start  time.Time
+	End    time.Time
+	Bucket time.Duration
+	Events int
+	Bytes  int64
+
+	groupBy  string
+	buckets  []int
+	streams  map[string]*StatsCount
+	values   map[string]*StatsCount
+	messages map[string]int
+}
+
+// NewStats returns an empty Stats for the window of opts
+func NewStats(opts StatsOptions) (*Stats, error) {
+	if opts.End.IsZero() {
+		opts.End = time.Now()
+	}
+	if !opts.End.After(opts.Start) {
+		return nil, fmt.Errorf("the end of the window must be after its start")
+	}
+	if opts.Bucket <= 0 {
+		opts.Bucket = statsBucketSize(opts.End.Sub(opts.Start))
+	}
+	start := opts.Start.Truncate(opts.Bucket)
+
+	count := (opts.End.Sub(start) + opts.Bucket - 1) / opts.Bucket
+	if count > maxStatsBuckets {
+		return nil, fmt.Errorf("a bucket of %s splits the window in %d buckets, the maximum is %d", opts.Bucket, count, maxStatsBuckets)
+	}
+
+	return &Stats{
+		Start:    start,
+		End:      opts.End,
+		Bucket:   opts.Bucket,
+		groupBy:  opts.GroupBy,
+		buckets:  make([]int, count),
+		streams:  map[string]*StatsCount{},
+		values:   map[string]*StatsCount{},
+		messages: map[string]int{},
+	}, nil
+}
+
+// statsBucketSize returns the smallest bucket size splitting window in at
+// most DefaultStatsBuckets buckets
+func statsBucketSize(window time.Duration) time.Duration {
+	for _, size := range statsBucketSizes {
+		if window/size <= DefaultStatsBuckets {
+			return size
+		}
+	}
+	return statsBucketSizes[len(statsBucketSizes)-1]
+}
+
+// Observe adds an event to the stats
+func (s *Stats) Observe(event Event) {
+	bucket := s.bucket(event.CreationTime)
+	s.Events++
+	s.Bytes += int64(len(event.Raw))
+	s.buckets[bucket]++
+
+	s.count(s.streams, event.Group+"\x00"+event.Stream, event.Group, event.Stream, event, bucket)
+	if s.groupBy != "" {
+		value, _ := event.Field(s.groupBy)
+		name := FormatValue(value)
+		s.count(s.values, name, "", name, event, bucket)
+	}
+
+	message := statsMessage(event)
+	if _, ok := s.messages[message]; ok || len(s.messages) < maxStatsMessages {
+		s.messages[message]++
+	}
+}
+
+func (s *Stats) count(counts map[string]*StatsCount, key string, group string, name string, event Event, bucket int) {
+	c, ok := counts[key]
+	if !ok {
+		c = &StatsCount{Group: group, Name: name, Buckets: make([]int, len(s.buckets))}
+		counts[key] = c
+	}
+	c.Events++
+	c.Bytes += int64(len(event.Raw))
+	c.Buckets[bucket]++
+}
+
+// bucket returns the histogram bucket of t, events outside the window are
+// counted in the first or last bucket
+func (s *Stats) bucket(t time.Time) int {
+	if t.Before(s.Start) {
+		return 0
+	}
+	i := int(t.Sub(s.Start) / s.Bucket)
+	if i >= len(s.buckets) {
+		return len(s.buckets) - 1
+	}
+	return i
+}
+
+// statsMessage returns the first line of the message of an event, or its
+// fields as JSON when it has none
+func statsMessage(event Event) string {
+	message, _, _ := strings.Cut(strings.TrimSpace(event.Message()), "\n")
+	if len(message) > maxStatsMessageLength {
+		message = strings.ToValidUTF8(message[:maxStatsMessageLength], "")
+	}
+	return message
+}
+
+// Histogram returns the number of events in each bucket, the first starting
+// at s.Start
+func (s *Stats) Histogram() []int {
+	return s.buckets
+}
+
+// Streams returns the counts of each stream, the busiest first
+func (s *Stats) Streams() []StatsCount {
+	return sortedCounts(s.streams)
+}
+
+// Values returns the counts of each value of the GroupBy field, the most
+// frequent first. Events without the field are counted under an empty value.
+func (s *Stats) Values() []StatsCount {
+	return sortedCounts(s.values)
+}
+
+// TopMessages returns the n most frequent messages
+func (s *Stats) TopMessages(n int) []MessageCount {
+	top := make([]MessageCount, 0, len(s.messages))
+	for message, events := range s.messages {
+		top = append(top, MessageCount{Message: message, Events: events})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Events != top[j].Events {
+			return top[i].Events > top[j].Events
+		}
+		return top[i].Message < top[j].Message
+	})
+
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func sortedCounts(counts map[string]*StatsCount) []StatsCount {
+	sorted := make([]StatsCount, 0, len(counts))
+	for _, c := range counts {
+		sorted = append(sorted, *c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Events != sorted[j].Events {
+			return sorted[i].Events > sorted[j].Events
+		}
+		if sorted[i].Group != sorted[j].Group {
+			return sorted[i].Group < sorted[j].Group
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
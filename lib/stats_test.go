@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsBucketSize(t *testing.T) {
+	tests := []struct {
+		window time.Duration
+		want   time.Duration
+	}{
+		{30 * time.Second, time.Second},
+		{time.Minute, time.Second},
+		{61 * time.Second, 5 * time.Second},
+		{time.Hour, time.Minute},
+		{90 * time.Minute, 5 * time.Minute},
+		{24 * time.Hour, 30 * time.Minute},
+		{7 * 24 * time.Hour, 3 * time.Hour},
+		{10 * 365 * 24 * time.Hour, 30 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := statsBucketSize(test.window); got != test.want {
+			t.Errorf("got %s buckets for a %s window, want %s", got, test.window, test.want)
+		}
+	}
+}
+
+func TestNewStats(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		opts    StatsOptions
+		want    string
+		wantErr string
+	}{
+		// The start is aligned to the bucket and the last bucket may be partial
+		{opts: StatsOptions{Start: start, End: start.Add(time.Hour)}, want: "10:07:00 1m0s 61"},
+		{opts: StatsOptions{Start: start, End: start.Add(time.Hour), Bucket: 15 * time.Minute}, want: "10:00:00 15m0s 5"},
+		{opts: StatsOptions{Start: start, End: start.Add(time.Minute), Bucket: 10 * time.Second}, want: "10:07:30 10s 6"},
+		{opts: StatsOptions{Start: start, End: start}, wantErr: "must be after its start"},
+		{opts: StatsOptions{Start: start, End: start.Add(24 * time.Hour), Bucket: time.Second}, wantErr: "86400 buckets, the maximum is 10000"},
+	}
+	for _, test := range tests {
+		stats, err := NewStats(test.opts)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(stats.Start.Format("15:04:05"), " ", stats.Bucket, " ", len(stats.Histogram())); got != test.want {
+			t.Errorf("got start, bucket and buckets %s, want %s", got, test.want)
+		}
+	}
+}
+
+// statsStart is the start of the window of TestStats, aligned to its buckets
+var statsStart = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// statsEvent returns an event of stream logged at minute of statsStart
+func statsEvent(group string, stream string, minute int, message string, fields ...string) Event {
+	event := Event{
+		Event:        map[string]interface{}{"message": message},
+		Group:        group,
+		Stream:       stream,
+		CreationTime: statsStart.Add(time.Duration(minute) * time.Minute),
+		Raw:          message,
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		event.Event[fields[i]] = fields[i+1]
+	}
+	return event
+}
+
+func TestStats(t *testing.T) {
+	stats, err := NewStats(StatsOptions{Start: statsStart, End: statsStart.Add(10 * time.Minute), Bucket: 2 * time.Minute, GroupBy: "level"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("x", maxStatsMessageLength+10)
+	for _, event := range []Event{
+		statsEvent("api", "a", 0, "started", "level", "info"),
+		statsEvent("api", "a", 1, "failed\nstack trace", "level", "error"),
+		statsEvent("api", "b", 3, "failed\nother trace", "level", "error"),
+		statsEvent("api", "b", 5, "  failed  ", "level", "error"),
+		statsEvent("web", "a", 9, long),
+		statsEvent("web", "a", 9, long+"y"),
+		// Events outside the window count in the first and last buckets
+		statsEvent("web", "b", -30, "early", "level", "info"),
+		statsEvent("web", "b", 20, "late", "level", "info"),
+	} {
+		stats.Observe(event)
+	}
+
+	if stats.Events != 8 || stats.Bytes != int64(7+18+18+10+2*len(long)+1+5+4) {
+		t.Errorf("got %d events and %d bytes", stats.Events, stats.Bytes)
+	}
+	if got := fmt.Sprint(stats.Histogram()); got != "[3 1 1 0 3]" {
+		t.Errorf("got histogram %s", got)
+	}
+
+	streams := []string{}
+	for _, c := range stats.Streams() {
+		streams = append(streams, fmt.Sprintf("%s/%s:%d:%d:%v", c.Group, c.Name, c.Events, c.Bytes, c.Buckets))
+	}
+	// The busiest streams come first, then by group and name
+	equalMessages(t, streams,
+		"api/a:2:25:[2 0 0 0 0]",
+		"api/b:2:28:[0 1 1 0 0]",
+		fmt.Sprintf("web/a:2:%d:[0 0 0 0 2]", 2*len(long)+1),
+		"web/b:2:9:[1 0 0 0 1]",
+	)
+
+	values := []string{}
+	for _, c := range stats.Values() {
+		values = append(values, fmt.Sprintf("%q:%d:%v", c.Name, c.Events, c.Buckets))
+	}
+	equalMessages(t, values, `"error":3:[1 1 1 0 0]`, `"info":3:[2 0 0 0 1]`, `"":2:[0 0 0 0 2]`)
+
+	messages := []string{}
+	for _, m := range stats.TopMessages(0) {
+		messages = append(messages, fmt.Sprintf("%d %.10s", m.Events, m.Message))
+	}
+	// Messages count by their first line cut to maxStatsMessageLength
+	equalMessages(t, messages, "3 failed", "2 xxxxxxxxxx", "1 early", "1 late", "1 started")
+	if top := stats.TopMessages(2); len(top) != 2 || top[1].Message != long[:maxStatsMessageLength] {
+		t.Errorf("got top messages %v", top)
+	}
+}
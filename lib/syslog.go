@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// syslogSeverities are the names of the RFC 5424 severities
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// parseSyslog parses RFC 5424 syslog messages such as
+// `<165>1 2003-10-11T22:14:15.003Z host app 1234 ID47 [id k="v"] message`.
+// Structured data is stored under structured_data.<id>.<param>.
+func parseSyslog(message string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(message, "<") {
+		return nil, false
+	}
+	end := strings.IndexByte(message, '>')
+	if end < 2 || end > 4 {
+		return nil, false
+	}
+	priority, err := strconv.Atoi(message[1:end])
+	if err != nil || priority > 191 {
+		return nil, false
+	}
+	rest := message[end+1:]
+
+	// VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	header := make([]string, 6)
+	for i := range header {
+		ix := strings.IndexByte(rest, ' ')
+		if ix <= 0 {
+			return nil, false
+		}
+		header[i] = rest[:ix]
+		rest = rest[ix+1:]
+	}
+	if version, err := strconv.Atoi(header[0]); err != nil || version < 1 {
+		return nil, false
+	}
+
+	fields := map[string]interface{}{
+		"priority": float64(priority),
+		"facility": float64(priority / 8),
+		"severity": syslogSeverities[priority%8],
+	}
+	for i, key := range []string{"timestamp", "hostname", "app_name", "procid", "msgid"} {
+		setUnlessDash(fields, key, header[i+1])
+	}
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		data, remaining, ok := parseStructuredData(rest)
+		if !ok {
+			return nil, false
+		}
+		fields["structured_data"] = data
+		rest = remaining
+	}
+	if rest != "" && rest[0] != ' ' {
+		return nil, false
+	}
+
+	msg := strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	fields["message"] = msg
+	return fields, true
+}
+
+// parseStructuredData parses one or more [id param="value"...] elements and
+// returns what follows them
+func parseStructuredData(s string) (map[string]interface{}, string, bool) {
+	data := map[string]interface{}{}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", false
+		}
+		params := map[string]interface{}{}
+		data[s[:end]] = params
+		s = s[end:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+			if s == "" {
+				return nil, "", false
+			}
+			if s[0] == ']' {
+				s = s[1:]
+				break
+			}
+
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return nil, "", false
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, "", false
+			}
+			params[name] = value.String()
+		}
+	}
+
+	return data, s, true
+}
@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTailLookback is how far back a shared tail starts reading
+	DefaultTailLookback = time.Minute
+	// tailReplay is the number of recent events sent to a new subscriber of
+	// a running tail
+	tailReplay = 100
+	// tailBuffer is the number of events held for a subscriber, further
+	// events are dropped until it catches up
+	tailBuffer = 1000
+)
+
+// TailOptions selects the events of a tail. Subscribers with the same options
+// share a reader.
+type TailOptions struct {
+	Group  string
+	Prefix string
+	Filter string
+}
+
+// TailHub follows groups for many subscribers, reading each group, stream
+// prefix and filter pattern once however many subscribers want it. A tail
+// is stopped when its last subscriber leaves.
+type TailHub struct {
+	ctx      context.Context
+	svc      LogSource
+	parser   Parser
+	lookback time.Duration
+	notify   func(string)
+
+	mu    sync.Mutex
+	tails map[TailOptions]*sharedTail
+}
+
+// sharedTail is a following reader and its subscribers
+type sharedTail struct {
+	opts   TailOptions
+	reader *CloudwatchLogsReader
+	cancel context.CancelFunc
+	subs   map[*Subscription]bool
+	recent []Event
+}
+
+// Subscription receives the events of a tail until it is closed or the
+// tail fails
+type Subscription struct {
+	hub     *TailHub
+	tail    *sharedTail
+	events  chan Event
+	err     error
+	dropped int
+}
+
+// NewTailHub returns a TailHub reading from svc until ctx is done
+func NewTailHub(ctx context.Context, svc LogSource) *TailHub {
+	return &TailHub{
+		ctx:      ctx,
+		svc:      svc,
+		lookback: DefaultTailLookback,
+		notify:   func(string) {},
+		tails:    map[TailOptions]*sharedTail{},
+	}
+}
+
+// SetParser sets the parser of the messages of every tail
+func (h *TailHub) SetParser(parser Parser) {
+	h.parser = parser
+}
+
+// SetLookback sets how far back new tails start reading
+func (h *TailHub) SetLookback(lookback time.Duration) {
+	h.lookback = lookback
+}
+
+// SetNotifyFunc sets a function receiving informational messages of the
+// readers, such as retries
+func (h *TailHub) SetNotifyFunc(notify func(string)) {
+	h.notify = notify
+}
+
+// Subscribe returns a subscription to the events of opts, starting with the
+// latest events of the tail if it is already running
+func (h *TailHub) Subscribe(opts TailOptions) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tail, ok := h.tails[opts]
+	if !ok {
+		var err error
+		tail, err = h.start(opts)
+		if err != nil {
+			return nil, err
+		}
+		h.tails[opts] = tail
+	}
+
+	sub := &Subscription{hub: h, tail: tail, events: make(chan Event, tailBuffer)}
+	for _, event := range tail.recent {
+		sub.events <- event
+	}
+	tail.subs[sub] = true
+	return sub, nil
+}
+
+// start starts reading a tail, it must be called with the lock held
+func (h *TailHub) start(opts TailOptions) (*sharedTail, error) {
+	reader, err := NewCloudwatchLogsReaderWithSource(h.svc, opts.Group, opts.Prefix, time.Now().Add(-h.lookback), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.SetFilterPattern(opts.Filter); err != nil {
+		return nil, err
+	}
+	if h.parser != nil {
+		reader.SetParser(h.parser)
+	}
+	reader.SetNotifyFunc(h.notify)
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	tail := &sharedTail{opts: opts, reader: reader, cancel: cancel, subs: map[*Subscription]bool{}}
+	go h.broadcast(tail, reader.StreamEvents(ctx, true))
+	return tail, nil
+}
+
+// broadcast sends the events of a tail to its subscribers, dropping them
+// for the subscribers that fall behind, and ends the subscriptions when the
+// reader stops
+func (h *TailHub) broadcast(tail *sharedTail, events <-chan Event) {
+	for event := range events {
+		h.mu.Lock()
+		tail.recent = append(tail.recent, event)
+		if len(tail.recent) > tailReplay {
+			tail.recent = tail.recent[len(tail.recent)-tailReplay:]
+		}
+		for sub := range tail.subs {
+			select {
+			case sub.events <- event:
+			default:
+				sub.dropped++
+			}
+		}
+		h.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Tails stopped by the hub or its subscribers end without an error
+	err := tail.reader.Error()
+	if errors.Is(err, context.Canceled) || h.ctx.Err() != nil {
+		err = nil
+	}
+	for sub := range tail.subs {
+		sub.err = err
+		h.remove(sub)
+	}
+	h.stop(tail)
+}
+
+// remove ends a subscription, it must be called with the lock held
+func (h *TailHub) remove(sub *Subscription) {
+	if !sub.tail.subs[sub] {
+		return
+	}
+	delete(sub.tail.subs, sub)
+	close(sub.events)
+}
+
+// stop stops reading a tail, it must be called with the lock held
+func (h *TailHub) stop(tail *sharedTail) {
+	tail.cancel()
+	if h.tails[tail.opts] == tail {
+		delete(h.tails, tail.opts)
+	}
+}
+
+// Tails returns the number of tails being read
+func (h *TailHub) Tails() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.tails)
+}
+
+// Events returns the channel of the events of the subscription, it is
+// closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the error that ended the subscription, if any
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of events dropped because the subscriber was
+// not reading them fast enough
+func (s *Subscription) Dropped() int {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Close ends the subscription, stopping its tail if it was the last one
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+	if len(s.tail.subs) == 0 {
+		s.hub.stop(s.tail)
+	}
+}
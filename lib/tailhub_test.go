@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// gatedSource returns a page of a MemoryLogSource for each value sent to
+// pages
+type gatedSource struct {
+	*MemoryLogSource
+	pages chan struct{}
+}
+
+func (g *gatedSource) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	select {
+	case <-g.pages:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return g.MemoryLogSource.FilterLogEvents(ctx, params, optFns...)
+}
+
+// newTestHub returns a hub reading svc from testStart, stopped at the end of
+// the test
+func newTestHub(t *testing.T, svc LogSource) *TailHub {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hub := NewTailHub(ctx, svc)
+	hub.SetLookback(time.Since(testStart))
+	return hub
+}
+
+func subscribe(t *testing.T, hub *TailHub, opts TailOptions) *Subscription {
+	t.Helper()
+	sub, err := hub.Subscribe(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// Subscribers of the same options share a reader and all get its events,
+// later ones starting with the latest events
+func TestTailHubFanOut(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(1), "a")
+	svc.AddEvent("group", "api/1", testTime(2), "b")
+	svc.AddEvent("group", "worker/1", testTime(3), "c")
+	hub := newTestHub(t, svc)
+
+	api := TailOptions{Group: "group", Prefix: "api/"}
+	first := subscribe(t, hub, api)
+	second := subscribe(t, hub, api)
+	equalMessages(t, receive(t, first.Events(), 2), "a", "b")
+	equalMessages(t, receive(t, second.Events(), 2), "a", "b")
+
+	late := subscribe(t, hub, api)
+	equalMessages(t, receive(t, late.Events(), 2), "a", "b")
+
+	all := subscribe(t, hub, TailOptions{Group: "group"})
+	equalMessages(t, receive(t, all.Events(), 3), "a", "b", "c")
+	if tails := hub.Tails(); tails != 2 {
+		t.Errorf("got %d tails, want 2", tails)
+	}
+
+	// The tail stops with its last subscriber
+	first.Close()
+	second.Close()
+	if tails := hub.Tails(); tails != 2 {
+		t.Errorf("got %d tails with a subscriber left, want 2", tails)
+	}
+	late.Close()
+	if tails := hub.Tails(); tails != 1 {
+		t.Errorf("got %d tails, want 1", tails)
+	}
+	if _, ok := <-late.Events(); ok {
+		t.Error("got an event after closing")
+	}
+	late.Close()
+	all.Close()
+	if tails := hub.Tails(); tails != 0 {
+		t.Errorf("got %d tails, want 0", tails)
+	}
+}
+
+// Subscribers that fall tailBuffer events behind miss the next ones, without
+// holding back the others
+func TestTailHubSlowSubscriber(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.PageSize = 500
+	for i := 0; i < tailBuffer+50; i++ {
+		svc.AddEvent("group", "api/1", testTime(i), fmt.Sprintf("event %d", i))
+	}
+	gated := &gatedSource{MemoryLogSource: svc, pages: make(chan struct{})}
+	hub := newTestHub(t, gated)
+
+	// Both subscribe before the first event is read, and the fast one reads
+	// every page before the next one is fetched
+	slow := subscribe(t, hub, TailOptions{Group: "group"})
+	defer slow.Close()
+	fast := subscribe(t, hub, TailOptions{Group: "group"})
+	defer fast.Close()
+
+	messages := []string{}
+	for _, n := range []int{500, 500, 50} {
+		gated.pages <- struct{}{}
+		messages = append(messages, receive(t, fast.Events(), n)...)
+	}
+	if messages[len(messages)-1] != fmt.Sprintf("event %d", tailBuffer+49) {
+		t.Errorf("got last event %s", messages[len(messages)-1])
+	}
+	if dropped := slow.Dropped(); dropped != 50 {
+		t.Errorf("got %d events dropped, want 50", dropped)
+	}
+	if dropped := fast.Dropped(); dropped != 0 {
+		t.Errorf("got %d events dropped for the fast subscriber", dropped)
+	}
+	messages = receive(t, slow.Events(), tailBuffer)
+	if messages[0] != "event 0" || messages[tailBuffer-1] != fmt.Sprintf("event %d", tailBuffer-1) {
+		t.Errorf("got events from %s to %s", messages[0], messages[tailBuffer-1])
+	}
+}
+
+// Stopping the hub ends the subscriptions without an error
+func TestTailHubStop(t *testing.T) {
+	svc := NewMemoryLogSource()
+	svc.AddEvent("group", "api/1", testTime(1), "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewTailHub(ctx, svc)
+	hub.SetLookback(time.Since(testStart))
+
+	sub := subscribe(t, hub, TailOptions{Group: "group"})
+	equalMessages(t, receive(t, sub.Events(), 1), "a")
+	cancel()
+
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Error("got an event after stopping")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not ended")
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if tails := hub.Tails(); tails != 0 {
+		t.Errorf("got %d tails, want 0", tails)
+	}
+}
@@ -112,3 +112,26 @@ func ParseAWSTimestamp(i *int64) time.Time {
 	}
 	return time.Unix(*i/1e3, (*i%1e3)*1e6)
 }
+
+// FormatOffset returns a signed duration rounded for display, e.g. -450ms,
+// +1.2s or +2m3.5s
+func FormatOffset(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	switch {
+	case d < time.Second:
+		d = d.Round(time.Millisecond)
+	case d < time.Hour:
+		d = d.Round(100 * time.Millisecond)
+	default:
+		d = d.Round(time.Second)
+	}
+	if d == 0 {
+		return "0s"
+	}
+	return sign + d.String()
+}
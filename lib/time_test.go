@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatOffset(t *testing.T) {
+	tests := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{-450 * time.Millisecond, "-450ms"},
+		{1200 * time.Millisecond, "+1.2s"},
+		{2*time.Minute + 3500*time.Millisecond, "+2m3.5s"},
+		{-(2*time.Minute + 3520*time.Millisecond), "-2m3.5s"},
+		{1234567 * time.Microsecond, "+1.2s"},
+		{450400 * time.Microsecond, "+450ms"},
+		{time.Hour + 2*time.Minute + 3600*time.Millisecond, "+1h2m4s"},
+		{0, "0s"},
+		{-400 * time.Microsecond, "0s"},
+	}
+	for _, test := range tests {
+		if got := FormatOffset(test.offset); got != test.want {
+			t.Errorf("FormatOffset(%s) = %s, want %s", test.offset, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultWatchWindow is the default sliding window of a rule
+	DefaultWatchWindow = time.Minute
+	// MaxAlertEvents is the maximum number of triggering events kept in an
+	// alert
+	MaxAlertEvents = 100
+)
+
+// WatchRules is the content of a rules file
+type WatchRules struct {
+	Rules []*WatchRule `yaml:"rules"`
+}
+
+// WatchRule fires when Threshold events matching its pattern and where
+// expression are logged within Window. It does not fire again until
+// Cooldown has passed.
+type WatchRule struct {
+	Name string `yaml:"name"`
+	// Group limits the rule to the groups matching a name or glob pattern
+	Group string `yaml:"group"`
+	// Pattern is a regular expression matched against the message
+	Pattern   string        `yaml:"pattern"`
+	Where     string        `yaml:"where"`
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+	Actions   []WatchAction `yaml:"actions"`
+
+	pattern *regexp.Regexp
+	where   *Where
+	// times holds the timestamps of the matching events within Window of
+	// the latest one
+	times []time.Time
+	// matches holds the latest of those events, up to Threshold or
+	// MaxAlertEvents
+	matches []Event
+	fired   time.Time
+}
+
+// WatchAction is run when a rule fires, exactly one of its fields is set
+type WatchAction struct {
+	// Command is run with sh -c, with the alert as JSON on its stdin
+	Command string `yaml:"command"`
+	// Webhook is a URL the alert is POSTed to as JSON
+	Webhook string `yaml:"webhook"`
+	// Banner prints the alert on the terminal
+	Banner bool `yaml:"banner"`
+}
+
+// Alert is a rule firing, with the events that triggered it
+type Alert struct {
+	Rule *WatchRule
+	Time time.Time
+	// Count is the number of matching events within the window of the rule
+	Count int
+	// Events holds the latest MaxAlertEvents of them
+	Events []Event
+}
+
+// LoadWatchRules reads the rules of a YAML file
+func LoadWatchRules(file string) ([]*WatchRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules '%s': %w", file, err)
+	}
+
+	rules, err := ParseWatchRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules '%s': %w", file, err)
+	}
+	return rules, nil
+}
+
+// ParseWatchRules parses and validates YAML rules, setting the defaults of
+// their optional fields
+func ParseWatchRules(data []byte) ([]*WatchRule, error) {
+	var rules WatchRules
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, err
+	}
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("no rules defined")
+	}
+
+	names := map[string]bool{}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name '%s'", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule '%s': %w", rule.Name, err)
+		}
+	}
+
+	return rules.Rules, nil
+}
+
+func (r *WatchRule) compile() error {
+	if r.Pattern == "" && r.Where == "" {
+		return fmt.Errorf("a pattern or where expression is required")
+	}
+
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.pattern = pattern
+	}
+	if r.Where != "" {
+		where, err := ParseWhere(r.Where)
+		if err != nil {
+			return err
+		}
+		r.where = where
+	}
+	if r.Group != "" {
+		if _, err := path.Match(r.Group, ""); err != nil {
+			return fmt.Errorf("invalid group pattern '%s': %w", r.Group, err)
+		}
+	}
+
+	if r.Threshold < 0 || r.Window < 0 || r.Cooldown < 0 {
+		return fmt.Errorf("threshold, window and cooldown can't be negative")
+	}
+	if r.Threshold == 0 {
+		r.Threshold = 1
+	}
+	if r.Window == 0 {
+		r.Window = DefaultWatchWindow
+	}
+	if r.Cooldown == 0 {
+		r.Cooldown = r.Window
+	}
+
+	if len(r.Actions) == 0 {
+		r.Actions = []WatchAction{{Banner: true}}
+	}
+	for _, action := range r.Actions {
+		set := 0
+		for _, ok := range []bool{action.Command != "", action.Webhook != "", action.Banner} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("each action needs exactly one of command, webhook or banner")
+		}
+
+		if action.Webhook != "" {
+			u, err := url.Parse(action.Webhook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid webhook URL '%s'", action.Webhook)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Match reports whether an event is matched by the rule
+func (r *WatchRule) Match(event Event) bool {
+	if r.Group != "" {
+		if ok, _ := path.Match(r.Group, event.Group); !ok {
+			return false
+		}
+	}
+	if r.pattern != nil && !r.pattern.MatchString(event.Message()) {
+		return false
+	}
+	return r.where == nil || r.where.Match(event)
+}
+
+// observe adds an event to the window of the rule and returns the alert it
+// fires, if any. Time is measured with the timestamps of the events.
+func (r *WatchRule) observe(event Event) (Alert, bool) {
+	if !r.Match(event) {
+		return Alert{}, false
+	}
+
+	// Drop the matches that fell out of the window, matches keeping only
+	// the latest events of the ones left
+	r.times = append(r.times, event.CreationTime)
+	expired := 0
+	for expired < len(r.times) && event.CreationTime.Sub(r.times[expired]) > r.Window {
+		expired++
+	}
+	r.times = r.times[expired:]
+
+	keep := MaxAlertEvents
+	if r.Threshold > keep {
+		keep = r.Threshold
+	}
+	if len(r.times) < keep {
+		keep = len(r.times)
+	}
+	r.matches = append(r.matches, event)
+	if len(r.matches) > keep {
+		r.matches = r.matches[len(r.matches)-keep:]
+	}
+
+	if len(r.times) < r.Threshold {
+		return Alert{}, false
+	}
+	if !r.fired.IsZero() && event.CreationTime.Sub(r.fired) < r.Cooldown {
+		return Alert{}, false
+	}
+
+	events := r.matches
+	if len(events) > MaxAlertEvents {
+		events = events[len(events)-MaxAlertEvents:]
+	}
+	alert := Alert{Rule: r, Time: event.CreationTime, Count: len(r.times), Events: append([]Event(nil), events...)}
+
+	r.fired = event.CreationTime
+	r.times, r.matches = nil, nil
+	return alert, true
+}
+
+// Watcher evaluates rules against a flow of events
+type Watcher struct {
+	rules []*WatchRule
+}
+
+// NewWatcher returns a Watcher evaluating rules
+func NewWatcher(rules []*WatchRule) *Watcher {
+	return &Watcher{rules: rules}
+}
+
+// Observe evaluates the rules against an event and returns the alerts it
+// fires
+func (w *Watcher) Observe(event Event) []Alert {
+	alerts := []Alert{}
+	for _, rule := range w.rules {
+		if alert, ok := rule.observe(event); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
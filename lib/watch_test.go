@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWatchRules(t *testing.T) {
+	rules, err := ParseWatchRules([]byte(`
+rules:
+  - name: errors
+    group: /ecs/*
+    pattern: ERROR
+    threshold: 3
+    window: 30s
+    cooldown: 5m
+    actions:
+      - command: echo fired
+      - webhook: https://hooks.example.com/alerts
+  - where: status >= 500
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+
+	first := rules[0]
+	if first.Threshold != 3 || first.Window != 30*time.Second || first.Cooldown != 5*time.Minute || len(first.Actions) != 2 {
+		t.Errorf("unexpected rule: %+v", first)
+	}
+
+	defaults := rules[1]
+	if defaults.Name != "rule-2" || defaults.Threshold != 1 || defaults.Window != DefaultWatchWindow || defaults.Cooldown != DefaultWatchWindow {
+		t.Errorf("defaults not set: %+v", defaults)
+	}
+	if len(defaults.Actions) != 1 || !defaults.Actions[0].Banner {
+		t.Errorf("got actions %+v, want a banner", defaults.Actions)
+	}
+}
+
+func TestParseWatchRulesErrors(t *testing.T) {
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{`rules: []`, "no rules defined"},
+		{`rules: [{name: a}]`, "a pattern or where expression is required"},
+		{`rules: [{pattern: "("}]`, "invalid pattern"},
+		{`rules: [{where: "status >"}]`, "invalid where expression"},
+		{`rules: [{pattern: x, group: "["}]`, "invalid group pattern"},
+		{`rules: [{pattern: x, threshold: -1}]`, "can't be negative"},
+		{`rules: [{pattern: x, window: soon}]`, "cannot unmarshal"},
+		{`rules: [{pattern: x, actions: [{}]}]`, "exactly one of"},
+		{`rules: [{pattern: x, actions: [{command: x, banner: true}]}]`, "exactly one of"},
+		{`rules: [{pattern: x, actions: [{webhook: "ftp://example.com"}]}]`, "invalid webhook URL"},
+		{`rules: [{name: a, pattern: x}, {name: a, pattern: y}]`, "duplicate rule name 'a'"},
+		{`rules: [{pattern: x, treshold: 2}]`, "field treshold not found"},
+	}
+	for _, test := range tests {
+		_, err := ParseWatchRules([]byte(test.rules))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.rules, err, test.err)
+		}
+	}
+}
+
+func TestWatchRuleObserve(t *testing.T) {
+	rules, err := ParseWatchRules([]byte(`
+rules:
+  - name: errors
+    group: /ecs/*
+    pattern: ERROR
+    where: status >= 500
+    threshold: 3
+    window: 10s
+    cooldown: 1m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewWatcher(rules)
+
+	observe := func(n int, group string, message string, status float64) []Alert {
+		return watcher.Observe(Event{
+			Group:        group,
+			Event:        map[string]interface{}{"message": message, "status": status},
+			CreationTime: testStart.Add(time.Duration(n) * time.Second),
+		})
+	}
+
+	// Events not matching the group, pattern or where expression don't count
+	for _, alerts := range [][]Alert{
+		observe(0, "/lambda/api", "ERROR", 500),
+		observe(0, "/ecs/api", "INFO", 500),
+		observe(0, "/ecs/api", "ERROR", 200),
+	} {
+		if len(alerts) != 0 {
+			t.Fatalf("non matching event fired %v", alerts)
+		}
+	}
+
+	// Matches further apart than the window don't fire
+	observe(1, "/ecs/api", "ERROR", 500)
+	observe(2, "/ecs/api", "ERROR", 500)
+	if alerts := observe(12, "/ecs/api", "ERROR", 500); len(alerts) != 0 {
+		t.Fatalf("matches outside of the window fired")
+	}
+
+	// The latest threshold matches within the window fire
+	alerts := observe(13, "/ecs/worker", "ERROR", 503)
+	if len(alerts) != 0 {
+		t.Fatalf("fired below the threshold")
+	}
+	alerts = observe(14, "/ecs/api", "ERROR", 500)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	if alert := alerts[0]; alert.Rule.Name != "errors" || len(alert.Events) != 3 || !alert.Time.Equal(testStart.Add(14*time.Second)) {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+
+	// The rule waits for its cooldown before firing again
+	for n := 15; n < 74; n++ {
+		if alerts := observe(n, "/ecs/api", "ERROR", 500); len(alerts) != 0 {
+			t.Fatalf("fired again %ds after, within the cooldown", n-14)
+		}
+	}
+	// Matches during the cooldown still count towards the next alert
+	alerts = observe(74, "/ecs/api", "ERROR", 500)
+	if len(alerts) != 1 {
+		t.Fatalf("did not fire again after the cooldown")
+	}
+	if alert := alerts[0]; alert.Count != 11 || len(alert.Events) != 11 || !alert.Events[0].CreationTime.Equal(testStart.Add(64*time.Second)) {
+		t.Errorf("got %d events counted and %d kept from %s", alert.Count, len(alert.Events), alert.Events[0].CreationTime)
+	}
+}
+
+// Alerts count every match within the window but only keep the latest
+// MaxAlertEvents
+func TestWatchRuleObserveCount(t *testing.T) {
+	rules, err := ParseWatchRules([]byte(`
+rules:
+  - name: errors
+    pattern: ERROR
+    window: 2h
+    cooldown: 1h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewWatcher(rules)
+
+	observe := func(n int) []Alert {
+		return watcher.Observe(Event{
+			Event:        map[string]interface{}{"message": fmt.Sprintf("ERROR %d", n)},
+			CreationTime: testStart.Add(time.Duration(n) * time.Second),
+		})
+	}
+
+	if alerts := observe(0); len(alerts) != 1 || alerts[0].Count != 1 || len(alerts[0].Events) != 1 {
+		t.Fatalf("got alerts %+v, want one with the first match", alerts)
+	}
+	for n := 1; n <= 2*MaxAlertEvents; n++ {
+		if alerts := observe(n); len(alerts) != 0 {
+			t.Fatalf("fired within the cooldown")
+		}
+	}
+	if len(rules[0].matches) != MaxAlertEvents {
+		t.Errorf("kept %d matches, want %d", len(rules[0].matches), MaxAlertEvents)
+	}
+
+	alerts := observe(3600)
+	if len(alerts) != 1 {
+		t.Fatalf("did not fire again after the cooldown")
+	}
+	alert := alerts[0]
+	if alert.Count != 2*MaxAlertEvents+1 || len(alert.Events) != MaxAlertEvents {
+		t.Errorf("got %d events counted and %d kept", alert.Count, len(alert.Events))
+	}
+	if first, last := alert.Events[0].Message(), alert.Events[len(alert.Events)-1].Message(); first != fmt.Sprintf("ERROR %d", MaxAlertEvents+2) || last != "ERROR 3600" {
+		t.Errorf("got events from %s to %s", first, last)
+	}
+}
@@ -0,0 +1,495 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Where is a compiled --where expression that matches events on their parsed
+// fields. Expressions support:
+//
+//   - dotted paths into the event fields: level, request.path
+//   - literals: "text", 'text', 42, 1.5, true, false, null
+//   - comparisons: == != < <= > >=
+//   - regular expressions: path ~ "^/api", path !~ "health"
+//   - lists: level in ("error", "warn"), level not in ("debug")
+//   - boolean logic: && || ! (or and, or, not) and parentheses
+//
+// A bare path is true when the field exists and is not false, null, 0 or "".
+type Where struct {
+	expression string
+	root       whereNode
+}
+
+// ParseWhere compiles a where expression
+func ParseWhere(expression string) (*Where, error) {
+	tokens, err := lexWhere(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid where expression '%s': %w", expression, err)
+	}
+
+	p := &whereParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid where expression '%s': %w", expression, err)
+	}
+
+	return &Where{expression: expression, root: root}, nil
+}
+
+// Match reports whether an event matches the expression
+func (w *Where) Match(event Event) bool {
+	return truthy(w.root.eval(event))
+}
+
+// String returns the source expression
+func (w *Where) String() string {
+	return w.expression
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type whereToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var whereOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "<", ">", "~", "!"}
+
+func lexWhere(input string) ([]whereToken, error) {
+	tokens := []whereToken{}
+	i := 0
+	for i < len(input) {
+		r := rune(input[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, whereToken{tokenLParen, "(", i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, whereToken{tokenRParen, ")", i + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, whereToken{tokenComma, ",", i + 1})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			var b strings.Builder
+			for ; end < len(input) && rune(input[end]) != r; end++ {
+				if input[end] == '\\' && end+1 < len(input) {
+					end++
+				}
+				b.WriteByte(input[end])
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, whereToken{tokenString, b.String(), i + 1})
+			i = end + 1
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			end := i + 1
+			for end < len(input) && strings.ContainsRune("0123456789.eE+-", rune(input[end])) {
+				// Only allow a sign right after an exponent
+				if (input[end] == '+' || input[end] == '-') && input[end-1] != 'e' && input[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			if _, err := strconv.ParseFloat(input[i:end], 64); err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at position %d", input[i:end], i+1)
+			}
+			tokens = append(tokens, whereToken{tokenNumber, input[i:end], i + 1})
+			i = end
+		case isIdentStart(r):
+			end := i + 1
+			for end < len(input) && (isIdentPart(rune(input[end])) || input[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, whereToken{tokenIdent, input[i:end], i + 1})
+			i = end
+		default:
+			matched := false
+			for _, op := range whereOperators {
+				if strings.HasPrefix(input[i:], op) {
+					tokens = append(tokens, whereToken{tokenOp, op, i + 1})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i+1)
+			}
+		}
+	}
+
+	return append(tokens, whereToken{tokenEOF, "end of expression", len(input) + 1}), nil
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '@' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || r == '-' || unicode.IsDigit(r)
+}
+
+// Parser
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is one of the given operators or
+// case insensitive keywords, and consumes it if so
+func (p *whereParser) keyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOp && t.kind != tokenIdent {
+		return false
+	}
+	for _, word := range words {
+		if (t.kind == tokenOp && t.text == word) || (t.kind == tokenIdent && strings.EqualFold(t.text, word)) {
+			p.next()
+			return true
+		}
+	}
+	return false
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseNot() (whereNode, error) {
+	if p.keyword("!", "not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOp && (t.text == "~" || t.text == "!~"):
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("expected a quoted regular expression at position %d", pattern.pos)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %w", pattern.pos, err)
+		}
+		return matchNode{left, re, t.text == "!~"}, nil
+	case t.kind == tokenOp && t.text != "&&" && t.text != "||" && t.text != "!":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{t.text, left, right}, nil
+	case t.kind == tokenIdent && (strings.EqualFold(t.text, "in") || strings.EqualFold(t.text, "not")):
+		negate := false
+		if strings.EqualFold(t.text, "not") {
+			// "not" here must be followed by "in", otherwise it is not ours
+			if p.pos+1 >= len(p.tokens) || !strings.EqualFold(p.tokens[p.pos+1].text, "in") {
+				return left, nil
+			}
+			p.next()
+			negate = true
+		}
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{left, values, negate}, nil
+	}
+
+	return left, nil
+}
+
+func (p *whereParser) parseList() ([]whereNode, error) {
+	if t := p.next(); t.kind != tokenLParen {
+		return nil, fmt.Errorf("expected '(' at position %d", t.pos)
+	}
+
+	values := []whereNode{}
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		}
+		if t.kind != tokenComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", t.pos)
+		}
+	}
+}
+
+func (p *whereParser) parseOperand() (whereNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ')' at position %d", closing.pos)
+		}
+		return node, nil
+	case tokenString:
+		return literalNode{t.text}, nil
+	case tokenNumber:
+		f, _ := strconv.ParseFloat(t.text, 64)
+		return literalNode{f}, nil
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null", "nil":
+			return literalNode{nil}, nil
+		}
+		return pathNode{t.text}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos)
+}
+
+// Evaluation
+
+type whereNode interface {
+	eval(event Event) interface{}
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(Event) interface{} { return n.value }
+
+type pathNode struct{ path string }
+
+func (n pathNode) eval(event Event) interface{} {
+	value, _ := event.Field(n.path)
+	return value
+}
+
+type notNode struct{ operand whereNode }
+
+func (n notNode) eval(event Event) interface{} { return !truthy(n.operand.eval(event)) }
+
+type andNode struct{ left, right whereNode }
+
+func (n andNode) eval(event Event) interface{} {
+	return truthy(n.left.eval(event)) && truthy(n.right.eval(event))
+}
+
+type orNode struct{ left, right whereNode }
+
+func (n orNode) eval(event Event) interface{} {
+	return truthy(n.left.eval(event)) || truthy(n.right.eval(event))
+}
+
+type matchNode struct {
+	operand whereNode
+	re      *regexp.Regexp
+	negate  bool
+}
+
+func (n matchNode) eval(event Event) interface{} {
+	value := n.operand.eval(event)
+	if value == nil {
+		return n.negate
+	}
+	return n.re.MatchString(FormatValue(value)) != n.negate
+}
+
+type inNode struct {
+	operand whereNode
+	values  []whereNode
+	negate  bool
+}
+
+func (n inNode) eval(event Event) interface{} {
+	value := n.operand.eval(event)
+	for _, candidate := range n.values {
+		if c, ok := compareValues(value, candidate.eval(event)); ok && c == 0 {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+type compareNode struct {
+	op          string
+	left, right whereNode
+}
+
+func (n compareNode) eval(event Event) interface{} {
+	left, right := n.left.eval(event), n.right.eval(event)
+
+	c, ok := compareValues(left, right)
+	if !ok {
+		// Values of different types (or missing fields) are never equal
+		return n.op == "!="
+	}
+
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareValues compares two parsed values and returns -1, 0 or 1. Strings
+// holding numbers are compared numerically against numbers. It returns false
+// if the values can not be compared.
+func compareValues(left, right interface{}) (int, bool) {
+	if left == nil || right == nil {
+		if left == nil && right == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if lf, ok := toNumber(left); ok {
+		if rf, ok := toNumber(right); ok {
+			switch {
+			case lf < rf:
+				return -1, true
+			case lf > rf:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case bool:
+		r, ok := right.(bool)
+		if !ok || l != r {
+			// Booleans are only equal or different
+			return 1, ok
+		}
+		return 0, true
+	}
+
+	return strings.Compare(FormatValue(left), FormatValue(right)), true
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWhere(t *testing.T) {
+	event := Event{Event: map[string]interface{}{
+		"level":   "error",
+		"status":  float64(503),
+		"code":    "42",
+		"version": "10",
+		"ok":      false,
+		"empty":   "",
+		"nothing": nil,
+		"request": map[string]interface{}{"path": "/api/users", "method": "GET"},
+	}}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		// Comparisons
+		{`level == "error"`, true},
+		{`level == 'error'`, true},
+		{`level != "error"`, false},
+		{`status >= 500`, true},
+		{`status < 500`, false},
+		{`request.method == "GET"`, true},
+		{`ok == false`, true},
+		{`nothing == null`, true},
+
+		// Numbers are compared numerically, also when held in strings
+		{`status == "503"`, true},
+		{`code > 5`, true},
+		{`version > "9"`, true},
+		{`status == 503.0`, true},
+		// Other strings are compared as strings
+		{`level > "debug"`, true},
+		{`level < "debug"`, false},
+
+		// Missing fields and values of different types are never equal
+		{`missing == "x"`, false},
+		{`missing != "x"`, true},
+		{`missing > 1`, false},
+		{`level == 1`, false},
+		{`ok == "false"`, false},
+
+		// Bare paths
+		{`level`, true},
+		{`ok`, false},
+		{`empty`, false},
+		{`missing`, false},
+		{`!missing`, true},
+
+		// Precedence: ! binds tighter than &&, which binds tighter than ||
+		{`level == "warn" && status > 0 || code == 42`, true},
+		{`code == 42 || level == "warn" && status == 0`, true},
+		{`(code == 42 || level == "warn") && status == 0`, false},
+		{`!level == "error"`, false},
+		{`!(level == "warn")`, true},
+		{`not level == "warn" and status > 500`, true},
+		{`level == "warn" or not ok`, true},
+
+		// Lists
+		{`level in ("error", "warn")`, true},
+		{`level in ("info")`, false},
+		{`status in (500, 503)`, true},
+		{`level not in ("debug", "info")`, true},
+		{`level not in ("error")`, false},
+		{`missing in ("x")`, false},
+		{`missing not in ("x")`, true},
+
+		// Regular expressions
+		{`request.path ~ "^/api/"`, true},
+		{`request.path ~ "^/health"`, false},
+		{`request.path !~ "health"`, true},
+		{`status ~ "^5"`, true},
+		{`missing ~ ".*"`, false},
+		{`missing !~ "x"`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			where, err := ParseWhere(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := where.Match(event); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWhereErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{``, ""},
+		{`level ==`, ""},
+		{`level == "error`, ""},
+		{`(level == "error"`, ""},
+		{`level == "error")`, "unexpected ')'"},
+		{`level in "error"`, ""},
+		{`level in ("error"`, ""},
+		{`path ~ "["`, ""},
+		{`path ~ 42 )`, ""},
+		{`level # "error"`, ""},
+		{`level == "error" level`, "unexpected 'level'"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := ParseWhere(test.expression)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), "invalid where expression") || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}